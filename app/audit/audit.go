@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one line of the audit log, written for every stop decision.
+// Value and Threshold are omitted when they are not finite numbers, which
+// JSON cannot represent; Reason still says what happened.
+type Entry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	RunID        string    `json:"run_id"`
+	ExperimentID string    `json:"experiment_id"`
+	User         string    `json:"user"`
+	Metric       string    `json:"metric"`
+	Value        *float64  `json:"value,omitempty"`
+	Threshold    *float64  `json:"threshold,omitempty"`
+	Reason       string    `json:"reason"`
+	Stopped      bool      `json:"stopped"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Log appends entries as JSON lines to a file opened in append-only mode.
+// It is safe for concurrent use.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens, creating if needed, the audit log at path.
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	return &Log{file: file}, nil
+}
+
+// Record appends entry and syncs it to disk before returning.
+func (l *Log) Record(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %v", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit entry: %v", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to flush audit log: %v", err)
+	}
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	value, threshold := 6.5, 5.0
+
+	for i := 0; i < 2; i++ {
+		log, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		err = log.Record(Entry{
+			Timestamp: time.Now(),
+			RunID:     "run-1",
+			Metric:    "loss",
+			Value:     &value,
+			Threshold: &threshold,
+			Stopped:   true,
+		})
+		if err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		log.Close()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var lines int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %d is not valid JSON: %v", lines, err)
+		}
+		if entry.RunID != "run-1" || *entry.Value != value || !entry.Stopped {
+			t.Errorf("line %d = %+v, want the recorded entry", lines, entry)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("got %d lines, want 2 (reopening must append)", lines)
+	}
+}
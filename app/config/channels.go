@@ -0,0 +1,11 @@
+package config
+
+// ForExperiment returns a copy of c whose MessageChannels is the
+// ExperimentChannels entry of experimentID, or c itself when the experiment
+// has none.
+func (c Config) ForExperiment(experimentID string) Config {
+	if channels, ok := c.ExperimentChannels[experimentID]; ok {
+		c.MessageChannels = channels
+	}
+	return c
+}
@@ -1,11 +1,21 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/gidra39/mlflow-autostop/validation"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/joho/godotenv"
+	kjson "github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/parsers/toml/v2"
+	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
@@ -18,22 +28,275 @@ var ErrFileNotFound = errors.New("file not found")
 // Config contains all application configuration settings
 // config/config.go - update the Config struct
 type Config struct {
-	MLflowTrackingURI           string             `json:"MLFLOW_TRACKING_URI" koanf:"MLFLOW_TRACKING_URI" validate:"required"`
-	TelegramBotToken            string             `json:"TELEGRAM_BOT_TOKEN" koanf:"TELEGRAM_BOT_TOKEN"`
-	TelegramChatID              string             `json:"TELEGRAM_CHAT_ID" koanf:"TELEGRAM_CHAT_ID"`
-	PollInterval                int                `json:"POLL_INTERVAL_SECONDS" koanf:"POLL_INTERVAL_SECONDS" validate:"required,gt=0"`
-	MetricThresholds            map[string]float64 `json:"METRIC_THRESHOLDS" koanf:"METRIC_THRESHOLDS"`
-	TelegramBotDefaultChannelID int                `json:"TELEGRAM_BOT_DEFAULT_CHANNEL_ID" koanf:"TELEGRAM_BOT_DEFAULT_CHANNEL_ID"`
-	SlackWebhookURL             string             `json:"SLACK_WEBHOOK_URL" koanf:"SLACK_WEBHOOK_URL"`
-	MessageChannels             string             `json:"MESSAGE_CHANNELS" koanf:"MESSAGE_CHANNELS" default:"TELEGRAM"`
+	// MLflowTrackingURI may list several tracking servers separated by
+	// commas; each is monitored by its own loop with the same rules and
+	// notification channels. MLflowTrackingToken may then list one token per
+	// server, in the same order.
+	MLflowTrackingURI string `json:"MLFLOW_TRACKING_URI" koanf:"MLFLOW_TRACKING_URI" validate:"required" secret:"url"`
+	// MLflowAPIBasePath is the path of the REST API below MLflowTrackingURI:
+	// /api/2.0/preview/mlflow for servers older than MLflow 1.0, or e.g.
+	// /mlflow-proxy/api/2.0/mlflow when a proxy mounts MLflow under a subpath.
+	MLflowAPIBasePath   string `json:"MLFLOW_API_BASE_PATH" koanf:"MLFLOW_API_BASE_PATH" default:"/api/2.0/mlflow"`
+	MLflowTrackingToken string `json:"MLFLOW_TRACKING_TOKEN" koanf:"MLFLOW_TRACKING_TOKEN" secret:"true"`
+	// trackingTokens holds the token of each server in MLflowTrackingURI once
+	// resolveTrackingURIs has applied fallbacks such as DATABRICKS_TOKEN, so
+	// a token resolved for one server is never sent to another. An empty
+	// entry leaves that server without a token.
+	trackingTokens           []string
+	MLflowClientCertFile     string `json:"MLFLOW_CLIENT_CERT_FILE" koanf:"MLFLOW_CLIENT_CERT_FILE" validate:"required_with=MLflowClientKeyFile"`
+	MLflowClientKeyFile      string `json:"MLFLOW_CLIENT_KEY_FILE" koanf:"MLFLOW_CLIENT_KEY_FILE" validate:"required_with=MLflowClientCertFile"`
+	MLflowCACertFile         string `json:"MLFLOW_CA_CERT_FILE" koanf:"MLFLOW_CA_CERT_FILE"`
+	MLflowInsecureSkipVerify bool   `json:"MLFLOW_INSECURE_SKIP_VERIFY" koanf:"MLFLOW_INSECURE_SKIP_VERIFY"`
+	OAuthTokenURL            string `json:"OAUTH_TOKEN_URL" koanf:"OAUTH_TOKEN_URL" validate:"omitempty,url" secret:"url"`
+	OAuthClientID            string `json:"OAUTH_CLIENT_ID" koanf:"OAUTH_CLIENT_ID" validate:"required_with=OAuthTokenURL"`
+	OAuthClientSecret        string `json:"OAUTH_CLIENT_SECRET" koanf:"OAUTH_CLIENT_SECRET" secret:"true"`
+	OAuthScopes              string `json:"OAUTH_SCOPES" koanf:"OAUTH_SCOPES"`
+	HTTPProxyURL             string `json:"HTTP_PROXY_URL" koanf:"HTTP_PROXY_URL" validate:"omitempty,url" secret:"url"`
+	// UserAgent overrides the User-Agent of every outbound request, which
+	// defaults to mlflow-autostop/<version>.
+	UserAgent               string         `json:"USER_AGENT" koanf:"USER_AGENT"`
+	TelegramBotToken        string         `json:"TELEGRAM_BOT_TOKEN" koanf:"TELEGRAM_BOT_TOKEN" secret:"true"`
+	TelegramChatID          string         `json:"TELEGRAM_CHAT_ID" koanf:"TELEGRAM_CHAT_ID" validate:"omitempty,telegram_chat_id"`
+	TelegramMessageThreadID int            `json:"TELEGRAM_MESSAGE_THREAD_ID" koanf:"TELEGRAM_MESSAGE_THREAD_ID" validate:"gte=0"`
+	PollInterval            int            `json:"POLL_INTERVAL_SECONDS" koanf:"POLL_INTERVAL_SECONDS" validate:"required,gt=0"`
+	ExperimentPollIntervals map[string]int `json:"EXPERIMENT_POLL_INTERVALS" koanf:"EXPERIMENT_POLL_INTERVALS" validate:"dive,gt=0"`
+	MaxIdleIntervalSeconds  int            `json:"MAX_IDLE_INTERVAL_SECONDS" koanf:"MAX_IDLE_INTERVAL_SECONDS" validate:"gte=0"`
+	GracePeriodSeconds      int            `json:"GRACE_PERIOD_SECONDS" koanf:"GRACE_PERIOD_SECONDS" validate:"gte=0"`
+	// MaxMetricAgeSeconds skips metrics whose latest value was logged longer
+	// ago than this, since a stale value says little about the run's current
+	// state. With NotifyStalledRuns, a run whose metrics are all stale is
+	// reported once as stalled. 0 disables the check.
+	MaxMetricAgeSeconds int        `json:"MAX_METRIC_AGE_SECONDS" koanf:"MAX_METRIC_AGE_SECONDS" validate:"gte=0"`
+	NotifyStalledRuns   bool       `json:"NOTIFY_STALLED_RUNS" koanf:"NOTIFY_STALLED_RUNS"`
+	MetricThresholds    Thresholds `json:"METRIC_THRESHOLDS" koanf:"METRIC_THRESHOLDS"`
+	WarnThresholds      Thresholds `json:"WARN_THRESHOLDS" koanf:"WARN_THRESHOLDS"`
+	// ThresholdEpsilon widens every threshold bound by this margin, so a run
+	// is only stopped once value > max + epsilon or value < min - epsilon.
+	// It keeps a metric sitting right on its threshold from flip-flopping
+	// across the boundary through floating-point noise.
+	ThresholdEpsilon float64 `json:"THRESHOLD_EPSILON" koanf:"THRESHOLD_EPSILON" validate:"gte=0"`
+	// RulesFile is a JSON, YAML or TOML file holding metric rules: any of
+	// METRIC_THRESHOLDS, WARN_THRESHOLDS, SMOOTHING_WINDOW,
+	// METRIC_AGGREGATION, METRIC_ALIASES, STEP_LIMITS, REQUIRED_METRICS,
+	// COMPUTED_METRICS and COMPOSITE_SCORES. Its entries are merged over the
+	// ones set here, so the rules can be versioned apart from the deployment
+	// config.
+	RulesFile string `json:"RULES_FILE" koanf:"RULES_FILE"`
+	// SmoothingWindow evaluates a metric's thresholds against the mean of its
+	// last N history points instead of the latest value. It needs the
+	// metrics/get-history endpoint, costing one extra request per smoothed
+	// metric per poll. A window of 1, or no entry, disables smoothing.
+	SmoothingWindow map[string]int `json:"SMOOTHING_WINDOW" koanf:"SMOOTHING_WINDOW" validate:"dive,gt=0"`
+	// MetricAggregation picks how a metric's SmoothingWindow points are
+	// reduced: mean (the default), max, min, or last to compare the latest
+	// value only. E.g. {"grad_norm": "max"} with a window of 50 stops a run
+	// whose grad_norm exceeded its threshold anywhere in the last 50 steps.
+	MetricAggregation map[string]string `json:"METRIC_AGGREGATION" koanf:"METRIC_AGGREGATION" validate:"dive,oneof=last max min mean"`
+	// MetricAliases lists, per canonical metric key, other keys the same
+	// metric is logged under by different frameworks, e.g.
+	// {"val_loss": ["validation_loss", "val/loss"]}. A run logging an alias
+	// but not the canonical key is evaluated as if it had logged the
+	// canonical key, so every rule keyed on val_loss applies to it. When it
+	// logs several aliases of one key, the first listed wins. An alias may
+	// belong to one canonical key only.
+	MetricAliases map[string][]string `json:"METRIC_ALIASES" koanf:"METRIC_ALIASES"`
+	// ComputedMetrics defines synthetic metrics as arithmetic over logged
+	// ones, e.g. {"overfit_gap": "val_loss - train_loss"}. They are checked
+	// against METRIC_THRESHOLDS and WARN_THRESHOLDS like any logged metric,
+	// once the run has logged every metric the expression references. Keys
+	// containing characters other than letters, digits, '_' and '.' are
+	// quoted with backticks: "`val/loss` - `train/loss`".
+	ComputedMetrics map[string]string `json:"COMPUTED_METRICS" koanf:"COMPUTED_METRICS" validate:"dive,metric_expression"`
+	// CompositeScores stops a run when a weighted score over several
+	// metrics crosses its threshold, keyed by a name used in notifications.
+	// A score whose metrics have not all been logged is skipped.
+	CompositeScores map[string]CompositeScore `json:"COMPOSITE_SCORES" koanf:"COMPOSITE_SCORES" validate:"dive"`
+	// WatchMetrics lists metrics whose latest value is logged for every run on
+	// every poll, for keeping an eye on trends from the logs.
+	WatchMetrics []string `json:"WATCH_METRICS" koanf:"WATCH_METRICS"`
+	// StepLimits stops a run once a metric is logged at a step past its limit.
+	StepLimits                  map[string]int `json:"STEP_LIMITS" koanf:"STEP_LIMITS" validate:"dive,gt=0"`
+	TelegramBotDefaultChannelID int            `json:"TELEGRAM_BOT_DEFAULT_CHANNEL_ID" koanf:"TELEGRAM_BOT_DEFAULT_CHANNEL_ID"`
+	SlackWebhookURL             string         `json:"SLACK_WEBHOOK_URL" koanf:"SLACK_WEBHOOK_URL" secret:"true"`
+	SlackCriticalWebhookURL     string         `json:"SLACK_CRITICAL_WEBHOOK_URL" koanf:"SLACK_CRITICAL_WEBHOOK_URL" secret:"true"`
+	SlackUseBlocks              bool           `json:"SLACK_USE_BLOCKS" koanf:"SLACK_USE_BLOCKS"`
+	TeamsWebhookURL             string         `json:"TEAMS_WEBHOOK_URL" koanf:"TEAMS_WEBHOOK_URL" secret:"true"`
+	PagerDutyRoutingKey         string         `json:"PAGERDUTY_ROUTING_KEY" koanf:"PAGERDUTY_ROUTING_KEY" secret:"true"`
+	PagerDutyOnlyCritical       bool           `json:"PAGERDUTY_ONLY_CRITICAL" koanf:"PAGERDUTY_ONLY_CRITICAL"`
+	EventWebhookURL             string         `json:"EVENT_WEBHOOK_URL" koanf:"EVENT_WEBHOOK_URL" validate:"omitempty,url" secret:"true"`
+	// EventStream writes a newline-delimited JSON event to stdout for every
+	// stop, warning and error, for piping into other tools. Logs stay on
+	// stderr.
+	EventStream bool `json:"EVENT_STREAM" koanf:"EVENT_STREAM"`
+	// WebhookSigningSecret signs every EventWebhookURL request with
+	// HMAC-SHA256 so the receiver can authenticate it. X-Timestamp holds the
+	// Unix time of the request and X-Signature is "sha256=" followed by the
+	// hex HMAC of "<timestamp>.<body>"; receivers should reject stale
+	// timestamps to prevent replays.
+	WebhookSigningSecret string   `json:"WEBHOOK_SIGNING_SECRET" koanf:"WEBHOOK_SIGNING_SECRET" secret:"true"`
+	OpsgenieAPIKey       string   `json:"OPSGENIE_API_KEY" koanf:"OPSGENIE_API_KEY" secret:"true"`
+	MatrixHomeserver     string   `json:"MATRIX_HOMESERVER" koanf:"MATRIX_HOMESERVER" validate:"omitempty,url"`
+	MatrixRoomID         string   `json:"MATRIX_ROOM_ID" koanf:"MATRIX_ROOM_ID"`
+	MatrixAccessToken    string   `json:"MATRIX_ACCESS_TOKEN" koanf:"MATRIX_ACCESS_TOKEN" secret:"true"`
+	KafkaBrokers         []string `json:"KAFKA_BROKERS" koanf:"KAFKA_BROKERS"`
+	KafkaTopic           string   `json:"KAFKA_TOPIC" koanf:"KAFKA_TOPIC"`
+	// NotificationGatewayURL receives the messages of the GATEWAY channel,
+	// for networks where only an internal gateway may call Slack, Telegram
+	// and the like. NotificationGatewayChannel names the gateway route the
+	// message is fanned out to.
+	NotificationGatewayURL     string `json:"NOTIFICATION_GATEWAY_URL" koanf:"NOTIFICATION_GATEWAY_URL" validate:"omitempty,url" secret:"url"`
+	NotificationGatewayChannel string `json:"NOTIFICATION_GATEWAY_CHANNEL" koanf:"NOTIFICATION_GATEWAY_CHANNEL" default:"default"`
+	MessageTemplate            string `json:"MESSAGE_TEMPLATE" koanf:"MESSAGE_TEMPLATE"`
+	MessageChannels            string `json:"MESSAGE_CHANNELS" koanf:"MESSAGE_CHANNELS" default:"TELEGRAM"`
+	// ExperimentChannels routes the stop, warning, stalled and missing
+	// metric notifications of runs in an experiment to its own channels,
+	// keyed by experiment ID and in the MESSAGE_CHANNELS format, e.g.
+	// {"12": "SLACK,TEAMS"}. Experiments without an entry use
+	// MESSAGE_CHANNELS.
+	ExperimentChannels map[string]string `json:"EXPERIMENT_CHANNELS" koanf:"EXPERIMENT_CHANNELS" validate:"dive,required"`
+	ActiveRunsFilter   string            `json:"ACTIVE_RUNS_FILTER" koanf:"ACTIVE_RUNS_FILTER" default:"attributes.status = 'RUNNING'"`
+	LogFormat          string            `json:"LOG_FORMAT" koanf:"LOG_FORMAT" default:"console" validate:"oneof=console json"`
+	LogLevel           string            `json:"LOG_LEVEL" koanf:"LOG_LEVEL" default:"info" validate:"oneof=trace debug info warn error"`
+	// SentryDSN reports errors of the monitor itself, such as MLflow being
+	// unreachable, to Sentry. Stopped runs are not reported there; they go
+	// through MESSAGE_CHANNELS. Empty disables Sentry.
+	SentryDSN         string `json:"SENTRY_DSN" koanf:"SENTRY_DSN" validate:"omitempty,url" secret:"true"`
+	NotifyOnStartup   bool   `json:"NOTIFY_ON_STARTUP" koanf:"NOTIFY_ON_STARTUP"`
+	NotifyOnShutdown  bool   `json:"NOTIFY_ON_SHUTDOWN" koanf:"NOTIFY_ON_SHUTDOWN"`
+	NotifyPollSummary bool   `json:"NOTIFY_POLL_SUMMARY" koanf:"NOTIFY_POLL_SUMMARY"`
+	// QuietWithinThreshold logs runs found within their thresholds at debug
+	// level instead of info, keeping large sweeps from flooding the logs
+	// every poll. Warnings and stops are still logged.
+	QuietWithinThreshold bool `json:"QUIET_WITHIN_THRESHOLD" koanf:"QUIET_WITHIN_THRESHOLD"`
+	// RequireNotification makes delivery a precondition for stopping. The
+	// notification is always sent before the stop call; when this is set and
+	// no channel delivers it, because each failed or skipped it (such as
+	// PagerDuty with PagerDutyOnlyCritical), the run is left running and is
+	// re-evaluated (and notified again) on the next poll. This trades a
+	// delayed stop, for as long as the channels are down, for never stopping
+	// a run unannounced.
+	RequireNotification bool `json:"REQUIRE_NOTIFICATION" koanf:"REQUIRE_NOTIFICATION"`
+	// ConfirmBeforeStop re-fetches a run once its search result violates a
+	// threshold and only stops it if the fresh details violate one too, so an
+	// inconsistent search index cannot stop a healthy run.
+	ConfirmBeforeStop bool `json:"CONFIRM_BEFORE_STOP" koanf:"CONFIRM_BEFORE_STOP"`
+	WriteStopNote     bool `json:"WRITE_STOP_NOTE" koanf:"WRITE_STOP_NOTE" default:"true"`
+	// LogStopMetric logs autostop.triggered=1 to a run just before stopping
+	// it, at the step of the violating metric, so the stop shows up in the
+	// MLflow UI charts.
+	LogStopMetric bool `json:"LOG_STOP_METRIC" koanf:"LOG_STOP_METRIC"`
+	// SoftStopTag asks a cooperative training script to checkpoint and exit
+	// instead of stopping the run outright: on a violation the tag is set to
+	// "true" and the run is only marked FAILED if it is still running
+	// SoftStopGraceSeconds later. The training script must poll its run for
+	// the tag, e.g. with MlflowClient.get_run, and finish the run itself.
+	SoftStopTag          string `json:"SOFT_STOP_TAG" koanf:"SOFT_STOP_TAG"`
+	SoftStopGraceSeconds int    `json:"SOFT_STOP_GRACE_SECONDS" koanf:"SOFT_STOP_GRACE_SECONDS" default:"300" validate:"gte=0"`
+	// StopDelaySeconds sets autostop.stopping=true on a run it is about to
+	// stop and waits this long before updating its status, giving a
+	// cooperative training script time to flush final artifacts. Unlike
+	// SoftStopTag the run is stopped within the same poll. 0 stops at once.
+	StopDelaySeconds int  `json:"STOP_DELAY_SECONDS" koanf:"STOP_DELAY_SECONDS" validate:"gte=0"`
+	StopOnNaN        bool `json:"STOP_ON_NAN" koanf:"STOP_ON_NAN" default:"true"`
+	// RequiredMetrics are metrics every run must keep logging, e.g. val_loss
+	// from an eval loop that may crash silently. A run that has not logged
+	// one within MissingMetricTimeoutSeconds of starting, or not updated it
+	// for that long, is warned about, or stopped with StopOnMissingMetric.
+	RequiredMetrics             []string `json:"REQUIRED_METRICS" koanf:"REQUIRED_METRICS"`
+	MissingMetricTimeoutSeconds int      `json:"MISSING_METRIC_TIMEOUT_SECONDS" koanf:"MISSING_METRIC_TIMEOUT_SECONDS" default:"900" validate:"gte=0"`
+	StopOnMissingMetric         bool     `json:"STOP_ON_MISSING_METRIC" koanf:"STOP_ON_MISSING_METRIC"`
+	// BaselineRunID stops a run once a metric it shares with the baseline
+	// run is more than RelativeTolerancePct worse than the baseline's value.
+	// As for MaxRegressionPct, the direction comes from the metric's
+	// threshold: a min bound only means higher-is-better, a max bound only
+	// lower-is-better; other metrics are not compared. The baseline is
+	// fetched once and refreshed every BaselineRefreshSeconds.
+	BaselineRunID          string  `json:"BASELINE_RUN_ID" koanf:"BASELINE_RUN_ID"`
+	RelativeTolerancePct   float64 `json:"RELATIVE_TOLERANCE_PCT" koanf:"RELATIVE_TOLERANCE_PCT" validate:"gte=0,lte=100"`
+	BaselineRefreshSeconds int     `json:"BASELINE_REFRESH_SECONDS" koanf:"BASELINE_REFRESH_SECONDS" default:"300" validate:"gte=0"`
+	// MaxRegressionPct stops a run once a metric falls back from the best
+	// value the run reached by more than this percentage, catching a run
+	// that collapses after converging. The direction comes from the metric's
+	// threshold: one with only a min bound is higher-is-better, one with only
+	// a max bound lower-is-better; other metrics are not checked. 0 disables.
+	MaxRegressionPct float64 `json:"MAX_REGRESSION_PCT" koanf:"MAX_REGRESSION_PCT" validate:"gte=0,lte=100"`
+	// StopAction is how a violating run is stopped: update marks it FAILED,
+	// delete moves it to MLflow's trash (runs/delete), from where it is
+	// purged by the server's garbage collection.
+	StopAction string `json:"STOP_ACTION" koanf:"STOP_ACTION" default:"update" validate:"oneof=update delete"`
+	// MaxStopsPerPoll caps how many runs a single poll may stop, guarding
+	// against a mistyped threshold wiping out a whole sweep. Further violating
+	// runs are left running and re-evaluated next poll. 0 means no limit.
+	MaxStopsPerPoll int    `json:"MAX_STOPS_PER_POLL" koanf:"MAX_STOPS_PER_POLL" validate:"gte=0"`
+	StatePath       string `json:"STATE_PATH" koanf:"STATE_PATH"`
+	// RecentlyStoppedTTLSeconds skips runs stopped less than this long ago
+	// while MLflow still reports them as running, so a status update that
+	// has not propagated yet, e.g. across a restart with STATE_PATH set,
+	// does not lead to a second stop and notification.
+	RecentlyStoppedTTLSeconds int  `json:"RECENTLY_STOPPED_TTL_SECONDS" koanf:"RECENTLY_STOPPED_TTL_SECONDS" default:"600" validate:"gte=0"`
+	PreserveLatest            bool `json:"PRESERVE_LATEST" koanf:"PRESERVE_LATEST"`
+	// RunScope limits monitoring to child runs, those with an
+	// mlflow.parentRunId tag such as HPO trials, or to top-level parent runs,
+	// which often stay RUNNING while their children finish.
+	RunScope     string `json:"RUN_SCOPE" koanf:"RUN_SCOPE" default:"all" validate:"oneof=all parents children"`
+	AuditLogPath string `json:"AUDIT_LOG_PATH" koanf:"AUDIT_LOG_PATH"`
+	// ReportPath is where -metrics-only writes its CSV report of every run.
+	ReportPath                    string `json:"REPORT_PATH" koanf:"REPORT_PATH" default:"report.csv"`
+	CircuitBreakerThreshold       int    `json:"CIRCUIT_BREAKER_THRESHOLD" koanf:"CIRCUIT_BREAKER_THRESHOLD" default:"5" validate:"gte=0"`
+	CircuitBreakerCooldownSeconds int    `json:"CIRCUIT_BREAKER_COOLDOWN_SECONDS" koanf:"CIRCUIT_BREAKER_COOLDOWN_SECONDS" default:"60" validate:"gte=0"`
+	SearchTimeoutSeconds          int    `json:"SEARCH_TIMEOUT_SECONDS" koanf:"SEARCH_TIMEOUT_SECONDS" default:"30" validate:"gte=0"`
+	MutationTimeoutSeconds        int    `json:"MUTATION_TIMEOUT_SECONDS" koanf:"MUTATION_TIMEOUT_SECONDS" default:"10" validate:"gte=0"`
 }
 
+// Values of Config.StopAction.
+const (
+	StopActionUpdate = "update"
+	StopActionDelete = "delete"
+)
+
+// Values of Config.RunScope.
+const (
+	RunScopeAll      = "all"
+	RunScopeParents  = "parents"
+	RunScopeChildren = "children"
+)
+
+// setDefaults seeds k with the values of the `default` struct tags so that
+// the file and environment providers loaded afterwards override them.
+func setDefaults(k *koanf.Koanf) {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value, ok := field.Tag.Lookup("default")
+		if !ok {
+			continue
+		}
+		if err := k.Set(field.Tag.Get("koanf"), value); err != nil {
+			log.Fatal().Err(err).Caller().Str("key", field.Name).Msg("koanf: error setting default")
+		}
+	}
+}
+
+// Load loads configFile, the defaults and the environment. A config file
+// that cannot be parsed is skipped with a warning.
 func Load(configFile string) Config {
+	return load(configFile, false)
+}
+
+// load loads configFile, the defaults and the environment. When required,
+// a config file that cannot be parsed is fatal; otherwise it is skipped with
+// a warning and the defaults and environment are used alone.
+func load(configFile string, required bool) Config {
 	k := koanf.New(".")
+	setDefaults(k)
 
 	if configFile != "" {
-		if err := k.Load(file.Provider(configFile), nil); err != nil {
-			log.Warn().Err(err).Str("file", configFile).Msg("unable to load config file")
+		if err := loadFile(k, configFile); err != nil {
+			event := log.Warn()
+			if required {
+				event = log.Fatal()
+			}
+			event.Err(err).Str("file", configFile).Msg("unable to load config file")
 		} else {
 			log.Info().Str("file", configFile).Msg("loaded configuration from file")
 		}
@@ -44,18 +307,205 @@ func Load(configFile string) Config {
 		log.Fatal().Err(err).Caller().Msg("koanf: error loading env")
 	}
 
-	config := Config{}
+	for _, value := range jsonValues {
+		if err := expandJSONValue(k, value); err != nil {
+			log.Fatal().Err(err).Caller().Msg("koanf: error decoding JSON setting")
+		}
+	}
 
-	if err := k.Unmarshal("", &config); err != nil {
+	config := Config{}
+	if err := k.UnmarshalWithConf("", &config, unmarshalConf(&config)); err != nil {
 		log.Fatal().Err(err).Caller().Msg("koanf: error unmarshalling config")
 	}
 
+	applyMLflowEnv(&config)
+
+	if config.RulesFile != "" {
+		rules, err := LoadRules(config.RulesFile)
+		if err != nil {
+			log.Fatal().Err(err).Caller().Msg("koanf: error loading rules")
+		}
+		rules.Apply(&config)
+		log.Info().Str("file", config.RulesFile).Msg("loaded rules from file")
+	}
+
+	normalizeTrackingURIs(&config)
+
 	if err := validation.Validate.Struct(config); err != nil {
 		log.Fatal().Err(err).Caller().Msg("koanf: error validating config")
 	}
+
+	if config.StopAction == StopActionDelete {
+		log.Warn().Msg("STOP_ACTION is delete: violating runs will be deleted, not marked failed. " +
+			"Deleted runs sit in MLflow's trash until garbage collected and must be restored from there")
+	}
+
+	if err := resolveTrackingURIs(&config); err != nil {
+		log.Fatal().Err(err).Caller().Msg("koanf: invalid MLFLOW_TRACKING_URI")
+	}
+
+	if _, err := template.New("message").Parse(config.MessageTemplate); err != nil {
+		log.Fatal().Err(err).Caller().Msg("koanf: invalid MESSAGE_TEMPLATE")
+	}
+
+	if err := validateThresholds(config.MetricThresholds); err != nil {
+		log.Fatal().Err(err).Caller().Msg("koanf: error validating config")
+	}
+
+	if err := validateThresholds(config.WarnThresholds); err != nil {
+		log.Fatal().Err(err).Caller().Msg("koanf: error validating config")
+	}
+
+	if err := validateMetricAliases(config.MetricAliases); err != nil {
+		log.Fatal().Err(err).Caller().Msg("koanf: error validating config")
+	}
 	return config
 }
 
+// validateMetricAliases rejects an alias listed under two canonical keys,
+// since a run logging it could then be evaluated as either.
+func validateMetricAliases(aliases map[string][]string) error {
+	owners := make(map[string]string)
+	for key, list := range aliases {
+		for _, alias := range list {
+			if owner, taken := owners[alias]; taken && owner != key {
+				first, second := owner, key
+				if second < first {
+					first, second = second, first
+				}
+				return fmt.Errorf("metric alias %s is listed under both %s and %s", alias, first, second)
+			}
+			owners[alias] = key
+		}
+	}
+	return nil
+}
+
+// unmarshalConf decodes into result, accepting the string forms env
+// variables use for durations, lists and thresholds.
+func unmarshalConf(result interface{}) koanf.UnmarshalConf {
+	return koanf.UnmarshalConf{
+		DecoderConfig: &mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToSliceHookFunc(","),
+				thresholdsDecodeHook,
+				thresholdDecodeHook),
+			Result:           result,
+			WeaklyTypedInput: true,
+		},
+	}
+}
+
+// loadFile loads configFile into k with the parser matching its extension.
+func loadFile(k *koanf.Koanf, configFile string) error {
+	var parser koanf.Parser
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".json":
+		parser = kjson.Parser()
+	case ".yaml", ".yml":
+		parser = yaml.Parser()
+	case ".toml":
+		parser = toml.Parser()
+	default:
+		return fmt.Errorf("unsupported config file extension %q, use .json, .yaml, .yml or .toml", filepath.Ext(configFile))
+	}
+	return k.Load(file.Provider(configFile), parser)
+}
+
+// jsonValue is a setting that may be given as JSON in a single variable,
+// with an example of its shape for error messages. list marks settings that
+// also accept a JSON list.
+type jsonValue struct {
+	key     string
+	example string
+	list    bool
+}
+
+var jsonValues = []jsonValue{
+	{"METRIC_THRESHOLDS", `{"loss": 5.0} or [{"metric": "loss", "op": ">", "value": 5.0}]`, true},
+	{"WARN_THRESHOLDS", `{"loss": 4.0} or [{"metric": "loss", "op": ">", "value": 4.0}]`, true},
+	{"COMPUTED_METRICS", `{"overfit_gap": "val_loss - train_loss"}`, false},
+	{"COMPOSITE_SCORES", `{"quality": {"weights": {"loss": 0.7}, "threshold": 0.5}}`, false},
+	{"METRIC_ALIASES", `{"val_loss": ["validation_loss", "val/loss"]}`, false},
+	{"EXPERIMENT_CHANNELS", `{"12": "SLACK,TEAMS"}`, false},
+}
+
+// expandJSONValue replaces a string at value.key with the JSON it encodes.
+// The env provider cannot build a map from a single variable, so this lets
+// METRIC_THRESHOLDS='{"loss": 5.0}' work alongside METRIC_THRESHOLDS.loss=5.
+func expandJSONValue(k *koanf.Koanf, value jsonValue) error {
+	raw, ok := k.Get(value.key).(string)
+	if !ok {
+		return nil
+	}
+
+	var decoded interface{}
+	err := json.Unmarshal([]byte(raw), &decoded)
+	if err == nil {
+		switch decoded.(type) {
+		case map[string]interface{}:
+		case []interface{}:
+			if !value.list {
+				err = fmt.Errorf("got a list")
+			}
+		default:
+			err = fmt.Errorf("got %T", decoded)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s must be JSON such as %s: %v", value.key, value.example, err)
+	}
+
+	k.Delete(value.key)
+	return k.Set(value.key, decoded)
+}
+
+// applyMLflowEnv falls back to the environment variables the MLflow CLI
+// itself reads when the tracking settings were not provided otherwise.
+func applyMLflowEnv(config *Config) {
+	if config.MLflowTrackingURI == "" {
+		config.MLflowTrackingURI = os.Getenv("MLFLOW_TRACKING_URI")
+	}
+	if config.MLflowTrackingToken == "" {
+		config.MLflowTrackingToken = os.Getenv("MLFLOW_TRACKING_TOKEN")
+	}
+}
+
+// resolveTrackingURI rejects tracking URIs this tool cannot talk to. MLflow
+// also accepts local paths and file: URIs, but those have no REST API. The
+// `databricks` URI is resolved to the workspace in DATABRICKS_HOST, with
+// DATABRICKS_TOKEN as the token fallback, like the MLflow client does.
+func resolveTrackingURI(config *Config) error {
+	uri := config.MLflowTrackingURI
+
+	if uri == "databricks" || strings.HasPrefix(uri, "databricks://") {
+		host := os.Getenv("DATABRICKS_HOST")
+		if host == "" {
+			return fmt.Errorf("MLFLOW_TRACKING_URI is %q but DATABRICKS_HOST is not set", uri)
+		}
+		if !strings.Contains(host, "://") {
+			host = "https://" + host
+		}
+		uri = strings.TrimSuffix(host, "/")
+		config.MLflowTrackingURI = uri
+
+		if config.MLflowTrackingToken == "" {
+			config.MLflowTrackingToken = os.Getenv("DATABRICKS_TOKEN")
+		}
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("MLFLOW_TRACKING_URI %q is not a valid URL: %v", uri, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("MLFLOW_TRACKING_URI %q is not supported: this tool requires an http(s) tracking server, "+
+			"e.g. http://localhost:5000", uri)
+	}
+	return nil
+}
+
 func SearchUpwardsForFile(filename string) (string, error) {
 	wd, err := os.Getwd()
 	if err != nil {
@@ -97,6 +547,10 @@ func LoadConfig(envFile string, configFiles ...string) Config {
 	}
 
 	for _, configFile := range configFiles {
+		if IsRemoteConfig(configFile) {
+			return loadRemote(configFile)
+		}
+
 		foundFile, err := SearchUpwardsForFile(configFile)
 		if err == nil {
 			return Load(foundFile)
@@ -106,3 +560,28 @@ func LoadConfig(envFile string, configFiles ...string) Config {
 	// If no config file found, load from environment only
 	return Load("")
 }
+
+// LoadConfigFile loads exactly configFile, a local path or remote URL,
+// without searching parent directories for it.
+func LoadConfigFile(envFile string, configFile string) Config {
+	if envFile != "" {
+		LoadDotEnv(envFile)
+	}
+
+	if IsRemoteConfig(configFile) {
+		return loadRemote(configFile)
+	}
+
+	if _, err := os.Stat(configFile); err != nil {
+		log.Fatal().Err(err).Str("file", configFile).Msg("unable to read config file")
+	}
+	return load(configFile, true)
+}
+
+func loadRemote(configFile string) Config {
+	cached, err := fetchRemoteConfig(configFile)
+	if err != nil {
+		log.Fatal().Err(err).Str("url", configFile).Msg("unable to load remote config")
+	}
+	return load(cached, true)
+}
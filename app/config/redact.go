@@ -0,0 +1,44 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// redactedValue replaces secrets in Redacted.
+const redactedValue = "REDACTED"
+
+// Redacted returns a copy of c with the fields tagged `secret:"true"`
+// replaced by REDACTED when set, and the passwords in URLs tagged
+// `secret:"url"` masked, safe to print or log.
+func (c Config) Redacted() Config {
+	v := reflect.ValueOf(&c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() != reflect.String || field.String() == "" {
+			continue
+		}
+		switch t.Field(i).Tag.Get("secret") {
+		case "true":
+			field.SetString(redactedValue)
+		case "url":
+			field.SetString(redactURLs(field.String()))
+		}
+	}
+	c.trackingTokens = nil
+	return c
+}
+
+// redactURLs masks the password of every URL in a comma-separated list.
+// Entries that do not parse are kept as they are.
+func redactURLs(value string) string {
+	urls := splitList(value)
+	for i, raw := range urls {
+		if u, err := url.Parse(raw); err == nil {
+			urls[i] = u.Redacted()
+		}
+	}
+	return strings.Join(urls, ",")
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gidra39/mlflow-autostop/version"
+	"github.com/rs/zerolog/log"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteTimeout bounds fetching a remote config at startup.
+const remoteTimeout = 30 * time.Second
+
+// IsRemoteConfig reports whether configFile is an s3:// or http(s) URL
+// rather than a local file name.
+func IsRemoteConfig(configFile string) bool {
+	return strings.HasPrefix(configFile, "s3://") ||
+		strings.HasPrefix(configFile, "https://") ||
+		strings.HasPrefix(configFile, "http://")
+}
+
+// fetchRemoteConfig downloads uri into the local cache and returns the path
+// of the cached copy. When the remote cannot be read, the copy cached by a
+// previous start is used instead. A config whose format cannot be told from
+// its extension or Content-Type is an error, since it could not be parsed.
+func fetchRemoteConfig(uri string) (string, error) {
+	dir, name := remoteCachePath(uri)
+
+	data, contentType, fetchErr := fetchRemote(uri)
+	if fetchErr == nil {
+		ext, err := remoteConfigExt(uri, contentType)
+		if err != nil {
+			return "", err
+		}
+
+		cachePath := filepath.Join(dir, name+ext)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", fmt.Errorf("failed to create config cache: %v", err)
+		}
+		if err := os.WriteFile(cachePath, data, 0o600); err != nil {
+			return "", fmt.Errorf("failed to cache remote config: %v", err)
+		}
+		log.Info().Str("url", uri).Str("cache", cachePath).Msg("fetched remote config")
+		return cachePath, nil
+	}
+
+	cached, _ := filepath.Glob(filepath.Join(dir, name+".*"))
+	if len(cached) == 0 {
+		return "", fmt.Errorf("failed to fetch remote config and no cached copy exists: %v", fetchErr)
+	}
+	log.Warn().Err(fetchErr).Str("url", uri).Str("cache", cached[0]).
+		Msg("remote config unreachable, using cached copy")
+	return cached[0], nil
+}
+
+// remoteCachePath returns the cache directory and the name, without
+// extension, of the cache file for uri, named after a hash of uri.
+func remoteCachePath(uri string) (dir string, name string) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	sum := sha256.Sum256([]byte(uri))
+	return filepath.Join(dir, "mlflow-autostop"), hex.EncodeToString(sum[:8])
+}
+
+// remoteConfigExt returns the extension loadFile parses the config at uri
+// by: that of the URL path when it has a supported one, otherwise the one
+// matching contentType.
+func remoteConfigExt(uri string, contentType string) (string, error) {
+	ext := strings.ToLower(path.Ext(strings.SplitN(uri, "?", 2)[0]))
+	switch ext {
+	case ".json", ".yaml", ".yml", ".toml":
+		return ext, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json":
+		return ".json", nil
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return ".yaml", nil
+	case "application/toml", "text/toml":
+		return ".toml", nil
+	}
+	return "", fmt.Errorf("cannot tell the format of remote config %s from its extension or Content-Type %q: "+
+		"use a .json, .yaml, .yml or .toml URL", uri, contentType)
+}
+
+// fetchRemote downloads uri and returns its body and Content-Type.
+func fetchRemote(uri string) ([]byte, string, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid config URL: %v", err)
+	}
+	if parsed.Scheme == "s3" {
+		return fetchS3(parsed.Host, strings.TrimPrefix(parsed.Path, "/"))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	// The config, and with it USER_AGENT, is not loaded yet.
+	req.Header.Set("User-Agent", version.UserAgent())
+
+	client := &http.Client{Timeout: remoteTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read remote config: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("remote config returned status code %d: %s", resp.StatusCode, string(body))
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// fetchS3 downloads bucket/key with the AWS SDK's default credential chain,
+// so environment variables, shared config files, IRSA web identity tokens
+// and instance profiles all work. AWS_ENDPOINT_URL switches to path-style
+// requests against an S3-compatible store such as MinIO.
+func fetchS3(bucket string, key string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	if cfg.Region == "" {
+		return nil, "", errors.New("no AWS region is configured for s3:// config: set AWS_REGION or a region in the AWS config file")
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = os.Getenv("AWS_ENDPOINT_URL") != ""
+	})
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get s3://%s/%s: %v", bucket, key, err)
+	}
+	defer out.Body.Close()
+
+	body, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read remote config: %v", err)
+	}
+	return body, aws.ToString(out.ContentType), nil
+}
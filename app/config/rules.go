@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/validation"
+	"github.com/knadh/koanf/v2"
+	"slices"
+)
+
+// Rules is the content of a RulesFile: the per-metric rules, kept apart from
+// the main config so they can be versioned and owned separately. The keys
+// match the Config fields of the same name.
+type Rules struct {
+	MetricThresholds  Thresholds                `json:"METRIC_THRESHOLDS" koanf:"METRIC_THRESHOLDS"`
+	WarnThresholds    Thresholds                `json:"WARN_THRESHOLDS" koanf:"WARN_THRESHOLDS"`
+	SmoothingWindow   map[string]int            `json:"SMOOTHING_WINDOW" koanf:"SMOOTHING_WINDOW" validate:"dive,gt=0"`
+	MetricAggregation map[string]string         `json:"METRIC_AGGREGATION" koanf:"METRIC_AGGREGATION" validate:"dive,oneof=last max min mean"`
+	MetricAliases     map[string][]string       `json:"METRIC_ALIASES" koanf:"METRIC_ALIASES"`
+	StepLimits        map[string]int            `json:"STEP_LIMITS" koanf:"STEP_LIMITS" validate:"dive,gt=0"`
+	RequiredMetrics   []string                  `json:"REQUIRED_METRICS" koanf:"REQUIRED_METRICS"`
+	ComputedMetrics   map[string]string         `json:"COMPUTED_METRICS" koanf:"COMPUTED_METRICS" validate:"dive,metric_expression"`
+	CompositeScores   map[string]CompositeScore `json:"COMPOSITE_SCORES" koanf:"COMPOSITE_SCORES" validate:"dive"`
+}
+
+// LoadRules reads and validates the JSON, YAML or TOML rules file at path.
+// It does not touch the rest of the config, so it can be called again to
+// pick up edits to the file.
+func LoadRules(path string) (Rules, error) {
+	k := koanf.New(".")
+	if err := loadFile(k, path); err != nil {
+		return Rules{}, fmt.Errorf("failed to load rules file %s: %v", path, err)
+	}
+
+	var rules Rules
+	if err := k.UnmarshalWithConf("", &rules, unmarshalConf(&rules)); err != nil {
+		return Rules{}, fmt.Errorf("failed to parse rules file %s: %v", path, err)
+	}
+
+	if err := validation.Validate.Struct(rules); err != nil {
+		return Rules{}, fmt.Errorf("invalid rules file %s: %v", path, err)
+	}
+	if err := validateThresholds(rules.MetricThresholds); err != nil {
+		return Rules{}, fmt.Errorf("invalid rules file %s: %v", path, err)
+	}
+	if err := validateThresholds(rules.WarnThresholds); err != nil {
+		return Rules{}, fmt.Errorf("invalid rules file %s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// Apply merges r into config. A metric listed in both takes its rule from r;
+// required metrics are added to those already configured.
+func (r Rules) Apply(config *Config) {
+	config.MetricThresholds = mergeMap(config.MetricThresholds, r.MetricThresholds)
+	config.WarnThresholds = mergeMap(config.WarnThresholds, r.WarnThresholds)
+	config.SmoothingWindow = mergeMap(config.SmoothingWindow, r.SmoothingWindow)
+	config.MetricAggregation = mergeMap(config.MetricAggregation, r.MetricAggregation)
+	config.MetricAliases = mergeMap(config.MetricAliases, r.MetricAliases)
+	config.StepLimits = mergeMap(config.StepLimits, r.StepLimits)
+	config.ComputedMetrics = mergeMap(config.ComputedMetrics, r.ComputedMetrics)
+	config.CompositeScores = mergeMap(config.CompositeScores, r.CompositeScores)
+
+	for _, metric := range r.RequiredMetrics {
+		if !slices.Contains(config.RequiredMetrics, metric) {
+			config.RequiredMetrics = append(config.RequiredMetrics, metric)
+		}
+	}
+}
+
+// mergeMap returns a copy of base with the entries of override set on top.
+func mergeMap[M ~map[string]V, V any](base M, override M) M {
+	if len(override) == 0 {
+		return base
+	}
+
+	merged := make(M, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		merged[key] = value
+	}
+	return merged
+}
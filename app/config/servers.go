@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// splitList splits a comma-separated setting, dropping blank entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// TrackingURIs returns the tracking servers listed in MLflowTrackingURI.
+func (c Config) TrackingURIs() []string {
+	return splitList(c.MLflowTrackingURI)
+}
+
+// Servers returns a copy of c for each tracking server in MLflowTrackingURI,
+// with MLflowTrackingURI set to that server alone. Each copy gets the token
+// resolved for its server when the config was loaded; otherwise, when
+// MLflowTrackingToken lists one token per server, the token in the same
+// position, and every server shares it when it does not.
+func (c Config) Servers() []Config {
+	uris := c.TrackingURIs()
+	if len(uris) <= 1 {
+		return []Config{c}
+	}
+
+	tokens := c.trackingTokens
+	if len(tokens) != len(uris) {
+		tokens = splitList(c.MLflowTrackingToken)
+	}
+	servers := make([]Config, len(uris))
+	for i, uri := range uris {
+		servers[i] = c
+		servers[i].MLflowTrackingURI = uri
+		servers[i].trackingTokens = nil
+		if len(tokens) == len(uris) {
+			servers[i].MLflowTrackingToken = tokens[i]
+		}
+	}
+	return servers
+}
+
+// SplitTrackingURI separates the user:password@ part of MLflowTrackingURI,
+// as used by servers behind basic auth, from the rest of the URI so the
+// credentials can be sent as an Authorization header and kept out of the
+// logs. The URI is returned unchanged when it carries no credentials or does
+// not parse.
+func (c Config) SplitTrackingURI() (uri string, username string, password string) {
+	u, err := url.Parse(c.MLflowTrackingURI)
+	if err != nil || u.User == nil {
+		return c.MLflowTrackingURI, "", ""
+	}
+
+	username = u.User.Username()
+	password, _ = u.User.Password()
+	u.User = nil
+	return u.String(), username, password
+}
+
+// DisplayTrackingURI returns MLflowTrackingURI without its credentials, for
+// logs and errors.
+func (c Config) DisplayTrackingURI() string {
+	uri, _, _ := c.SplitTrackingURI()
+	return uri
+}
+
+// normalizeTrackingURIs trims the list in MLflowTrackingURI. A URI copied
+// from the browser often ends in a slash, which would otherwise produce
+// //api/2.0/... endpoints that some servers reject.
+func normalizeTrackingURIs(config *Config) {
+	uris := config.TrackingURIs()
+	for i, uri := range uris {
+		uris[i] = strings.TrimSuffix(uri, "/")
+	}
+	config.MLflowTrackingURI = strings.Join(uris, ",")
+}
+
+// resolveTrackingURIs applies resolveTrackingURI to every listed server and
+// checks that a list of tokens matches the list of servers. The token each
+// server ends up with is kept in trackingTokens for Servers;
+// MLflowTrackingToken itself is left as configured.
+func resolveTrackingURIs(config *Config) error {
+	uris := config.TrackingURIs()
+	if len(uris) <= 1 {
+		return resolveTrackingURI(config)
+	}
+
+	tokens := splitList(config.MLflowTrackingToken)
+	if len(tokens) > 1 && len(tokens) != len(uris) {
+		return fmt.Errorf("MLFLOW_TRACKING_TOKEN lists %d tokens for %d tracking servers", len(tokens), len(uris))
+	}
+
+	config.trackingTokens = make([]string, len(uris))
+	for i, uri := range uris {
+		server := *config
+		server.MLflowTrackingURI = uri
+		if len(tokens) == len(uris) {
+			server.MLflowTrackingToken = tokens[i]
+		}
+		if err := resolveTrackingURI(&server); err != nil {
+			return err
+		}
+		uris[i] = server.MLflowTrackingURI
+		config.trackingTokens[i] = server.MLflowTrackingToken
+	}
+	config.MLflowTrackingURI = strings.Join(uris, ",")
+	return nil
+}
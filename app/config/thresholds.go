@@ -0,0 +1,307 @@
+package config
+
+import (
+	"fmt"
+	"github.com/go-viper/mapstructure/v2"
+	"math"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// regexPrefix marks a threshold key as a regular expression rather than a
+// metric name or glob.
+const regexPrefix = "re:"
+
+// patterns caches compiled regular expressions by threshold key.
+var patterns sync.Map
+
+// Threshold bounds the latest value of a metric. A bare number in config
+// keeps its original meaning of an upper bound; an object with `min` and/or
+// `max` describes a band the value has to stay within. A bound may instead
+// name a run param holding it, via `min_param`/`max_param` or the scalar
+// form `param:<name>` for an upper bound.
+type Threshold struct {
+	Min      *float64 `json:"min,omitempty" koanf:"min"`
+	Max      *float64 `json:"max,omitempty" koanf:"max"`
+	MinParam string   `json:"min_param,omitempty" koanf:"min_param"`
+	MaxParam string   `json:"max_param,omitempty" koanf:"max_param"`
+	// Patience is how many consecutive polls a bound has to be violated
+	// before the run is stopped; 0 and 1 stop on the first. NaN and Inf
+	// values still stop at once.
+	Patience int `json:"patience,omitempty" koanf:"patience"`
+	// WarmupSteps ignores the bounds while the metric is logged at a lower
+	// step, e.g. while the loss is still settling.
+	WarmupSteps int `json:"warmup_steps,omitempty" koanf:"warmup_steps"`
+	// Severity of the stop notification, SeverityError by default.
+	Severity string `json:"severity,omitempty" koanf:"severity"`
+}
+
+// Threshold severities. A critical stop is paged like a diverged metric.
+const (
+	SeverityError    = "error"
+	SeverityCritical = "critical"
+)
+
+// ThresholdRule is the list form of METRIC_THRESHOLDS, one entry per bound:
+//
+//	METRIC_THRESHOLDS:
+//	  - metric: loss
+//	    op: ">"
+//	    value: 5
+//	    patience: 3
+//	    warmup_steps: 100
+//	    severity: critical
+//
+// Op ">" stops the run once the metric exceeds Value and "<" once it falls
+// below it. The rules are compiled into the same Thresholds the map form
+// decodes to, so every check reads one rule set whichever form was used.
+type ThresholdRule struct {
+	Metric      string  `json:"metric" koanf:"metric"`
+	Op          string  `json:"op" koanf:"op"`
+	Value       float64 `json:"value" koanf:"value"`
+	Patience    int     `json:"patience,omitempty" koanf:"patience"`
+	WarmupSteps int     `json:"warmup_steps,omitempty" koanf:"warmup_steps"`
+	Severity    string  `json:"severity,omitempty" koanf:"severity"`
+}
+
+// Ops of a ThresholdRule.
+const (
+	OpAbove = ">"
+	OpBelow = "<"
+)
+
+// paramPrefix marks a scalar threshold as the name of a run param.
+const paramPrefix = "param:"
+
+// Thresholds maps metric names, globs or `re:` regexes to their Threshold.
+type Thresholds map[string]Threshold
+
+// CompositeScore stops a run once the weighted sum of its metrics, plus
+// Bias, exceeds Threshold. E.g. 0.7*loss + 0.3*(1-accuracy) > 0.5 is
+// {"weights": {"loss": 0.7, "accuracy": -0.3}, "bias": 0.3, "threshold": 0.5}.
+type CompositeScore struct {
+	Weights   map[string]float64 `json:"weights" koanf:"weights" validate:"required"`
+	Bias      float64            `json:"bias,omitempty" koanf:"bias"`
+	Threshold float64            `json:"threshold" koanf:"threshold"`
+}
+
+// Critical reports whether stops caused by t are paged as critical.
+func (t Threshold) Critical() bool {
+	return t.Severity == SeverityCritical
+}
+
+// MaxThreshold returns a Threshold with only an upper bound.
+func MaxThreshold(max float64) Threshold {
+	return Threshold{Max: &max}
+}
+
+func (t Threshold) String() string {
+	var bounds []string
+	if min := boundString(t.Min, t.MinParam); min != "" {
+		bounds = append(bounds, "min "+min)
+	}
+	if max := boundString(t.Max, t.MaxParam); max != "" {
+		bounds = append(bounds, "max "+max)
+	}
+	if len(bounds) == 0 {
+		return "unbounded"
+	}
+	return strings.Join(bounds, ", ")
+}
+
+func boundString(value *float64, param string) string {
+	switch {
+	case value != nil:
+		return fmt.Sprint(*value)
+	case param != "":
+		return paramPrefix + param
+	}
+	return ""
+}
+
+// CompileRules merges rules into Thresholds. Two rules for the same metric
+// become one band, as a map entry with both min and max would; their
+// patience, warmup and severity have to agree.
+func CompileRules(rules []ThresholdRule) (Thresholds, error) {
+	thresholds := make(Thresholds, len(rules))
+	for i, rule := range rules {
+		if rule.Metric == "" {
+			return nil, fmt.Errorf("threshold rule %d has no metric", i)
+		}
+
+		threshold, exists := thresholds[rule.Metric]
+		if exists && (threshold.Patience != rule.Patience || threshold.WarmupSteps != rule.WarmupSteps ||
+			threshold.Severity != rule.Severity) {
+			return nil, fmt.Errorf("threshold rules for %s disagree on patience, warmup_steps or severity", rule.Metric)
+		}
+		threshold.Patience = rule.Patience
+		threshold.WarmupSteps = rule.WarmupSteps
+		threshold.Severity = rule.Severity
+
+		value := rule.Value
+		switch rule.Op {
+		case OpAbove, "":
+			if threshold.Max != nil {
+				return nil, fmt.Errorf("threshold rules for %s set two upper bounds", rule.Metric)
+			}
+			threshold.Max = &value
+		case OpBelow:
+			if threshold.Min != nil {
+				return nil, fmt.Errorf("threshold rules for %s set two lower bounds", rule.Metric)
+			}
+			threshold.Min = &value
+		default:
+			return nil, fmt.Errorf("threshold rule for %s has op %q, want %q or %q", rule.Metric, rule.Op, OpAbove, OpBelow)
+		}
+		thresholds[rule.Metric] = threshold
+	}
+	return thresholds, nil
+}
+
+// thresholdsDecodeHook compiles the list form of a Thresholds value; the
+// map form is left to the default decoding and thresholdDecodeHook.
+func thresholdsDecodeHook(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+	if t != reflect.TypeOf(Thresholds{}) || f.Kind() != reflect.Slice {
+		return data, nil
+	}
+
+	var rules []ThresholdRule
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName:          "koanf",
+		WeaklyTypedInput: true,
+		Result:           &rules,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(data); err != nil {
+		return nil, fmt.Errorf("invalid threshold rules: %v", err)
+	}
+	return CompileRules(rules)
+}
+
+// thresholdDecodeHook converts a scalar config value into a max-only
+// Threshold so the legacy `METRIC_THRESHOLDS.<metric>=<value>` form works.
+func thresholdDecodeHook(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+	if t != reflect.TypeOf(Threshold{}) {
+		return data, nil
+	}
+
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		value := reflect.ValueOf(data).Convert(reflect.TypeOf(float64(0))).Float()
+		return MaxThreshold(value), nil
+	case reflect.String:
+		raw := strings.TrimSpace(data.(string))
+		if param, ok := strings.CutPrefix(raw, paramPrefix); ok {
+			return Threshold{MaxParam: param}, nil
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q: %v", data, err)
+		}
+		return MaxThreshold(value), nil
+	}
+
+	return data, nil
+}
+
+// Lookup returns the threshold that applies to metric. A key may be
+// the metric name, a glob such as `val_loss_class_*`, or a regular
+// expression prefixed with `re:`. An exact key always wins; otherwise the
+// first matching pattern in key order is used. Since `.` separates config
+// keys, a regex has to spell a literal dot as `\x2e`.
+func (thresholds Thresholds) Lookup(metric string) (Threshold, bool) {
+	if threshold, ok := thresholds[metric]; ok {
+		return threshold, true
+	}
+
+	keys := make([]string, 0, len(thresholds))
+	for key := range thresholds {
+		if isPattern(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if matchKey(key, metric) {
+			return thresholds[key], true
+		}
+	}
+	return Threshold{}, false
+}
+
+func isPattern(key string) bool {
+	return strings.HasPrefix(key, regexPrefix) || strings.ContainsAny(key, "*?[")
+}
+
+func matchKey(key string, metric string) bool {
+	if !strings.HasPrefix(key, regexPrefix) {
+		matched, err := path.Match(key, metric)
+		return err == nil && matched
+	}
+
+	re, err := compilePattern(key)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(metric)
+}
+
+// compilePattern compiles a `re:` key, anchored so the expression has to
+// match the whole metric name like a glob does.
+func compilePattern(key string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(key); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile("^(?:" + strings.TrimPrefix(key, regexPrefix) + ")$")
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(key, re)
+	return re, nil
+}
+
+func validateThresholds(thresholds Thresholds) error {
+	for metric, threshold := range thresholds {
+		if strings.HasPrefix(metric, regexPrefix) {
+			if _, err := compilePattern(metric); err != nil {
+				return fmt.Errorf("threshold key %s is not a valid regex: %v", metric, err)
+			}
+		} else if _, err := path.Match(metric, ""); err != nil {
+			return fmt.Errorf("threshold key %s is not a valid glob: %v", metric, err)
+		}
+		if threshold.Min == nil && threshold.Max == nil && threshold.MinParam == "" && threshold.MaxParam == "" {
+			return fmt.Errorf("threshold for %s needs a min or max bound", metric)
+		}
+		if (threshold.Min != nil && threshold.MinParam != "") || (threshold.Max != nil && threshold.MaxParam != "") {
+			return fmt.Errorf("threshold for %s sets a bound both as a value and as a param", metric)
+		}
+		for _, bound := range []*float64{threshold.Min, threshold.Max} {
+			if bound != nil && (math.IsNaN(*bound) || math.IsInf(*bound, 0)) {
+				return fmt.Errorf("threshold for %s must be a finite number, got %v", metric, *bound)
+			}
+		}
+		if threshold.Min != nil && threshold.Max != nil && *threshold.Min > *threshold.Max {
+			return fmt.Errorf("threshold for %s has min %v greater than max %v",
+				metric, *threshold.Min, *threshold.Max)
+		}
+		if threshold.Patience < 0 || threshold.WarmupSteps < 0 {
+			return fmt.Errorf("threshold for %s must not have a negative patience or warmup_steps", metric)
+		}
+		if threshold.Severity != "" && threshold.Severity != SeverityError && threshold.Severity != SeverityCritical {
+			return fmt.Errorf("threshold for %s has severity %q, want %s or %s",
+				metric, threshold.Severity, SeverityError, SeverityCritical)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Event types sent to EventWebhookURL.
+const (
+	TypeMonitorStarted = "monitor_started"
+	TypeMonitorStopped = "monitor_stopped"
+	TypeRunStarted     = "run_started"
+	TypeRunFinished    = "run_finished"
+	TypeWarning        = "warning"
+	TypeStopped        = "stopped"
+	TypeError          = "error"
+)
+
+// Event is a machine-readable record of a monitor state transition. Unlike
+// notifications it is sent for every transition and is not meant for humans.
+type Event struct {
+	Type         string    `json:"type"`
+	Time         time.Time `json:"time"`
+	Target       string    `json:"target,omitempty"`
+	RunID        string    `json:"run_id,omitempty"`
+	RunName      string    `json:"run_name,omitempty"`
+	ExperimentID string    `json:"experiment_id,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	Metric       string    `json:"metric,omitempty"`
+	Value        *float64  `json:"value,omitempty"`
+	Threshold    *float64  `json:"threshold,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Emit posts event to EventWebhookURL when one is configured and, with
+// EventStream, writes stops, warnings and errors to stdout. Delivery
+// failures are logged and otherwise ignored.
+func Emit(event Event, config config.Config) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	if config.EventStream && streamed(event.Type) {
+		writeStream(event)
+	}
+	if config.EventWebhookURL == "" {
+		return
+	}
+
+	if err := post(event, config); err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("failed to emit event")
+	}
+}
+
+func post(event Event, config config.Config) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	client, err := httpclient.For(config)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, config.EventWebhookURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build event request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.WebhookSigningSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Signature", "sha256="+sign(config.WebhookSigningSecret, timestamp, payload))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("event webhook returned status code %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// sign returns the hex HMAC-SHA256 of "<timestamp>.<payload>" under secret.
+// Covering the timestamp keeps a captured request from being replayed with
+// a fresh X-Timestamp.
+func sign(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
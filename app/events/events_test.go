@@ -0,0 +1,89 @@
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gidra39/mlflow-autostop/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmitPostsEvent(t *testing.T) {
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		received = append(received, event)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	Emit(Event{Type: TypeRunStarted, RunID: "run-1"}, config.Config{EventWebhookURL: server.URL})
+	Emit(Event{Type: TypeStopped, RunID: "run-1"}, config.Config{})
+
+	if len(received) != 1 {
+		t.Fatalf("got %d events, want only the one with a webhook configured", len(received))
+	}
+	if received[0].Type != TypeRunStarted || received[0].RunID != "run-1" || received[0].Time.IsZero() {
+		t.Errorf("event = %+v, want a timestamped run_started for run-1", received[0])
+	}
+}
+
+func TestEmitSignsEvent(t *testing.T) {
+	var body []byte
+	var timestamp, signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		timestamp = r.Header.Get("X-Timestamp")
+		signature = r.Header.Get("X-Signature")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	Emit(Event{Type: TypeStopped, RunID: "run-1"}, config.Config{EventWebhookURL: server.URL, WebhookSigningSecret: "secret"})
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); timestamp == "" || signature != want {
+		t.Errorf("X-Timestamp = %q, X-Signature = %q, want %q", timestamp, signature, want)
+	}
+}
+
+func TestEmitStreamsStopsWarningsAndErrors(t *testing.T) {
+	var out bytes.Buffer
+	original := streamOut
+	streamOut = &out
+	defer func() { streamOut = original }()
+
+	value, threshold := 5.0, 1.0
+	cfg := config.Config{EventStream: true}
+	Emit(Event{Type: TypeRunStarted, RunID: "run-1"}, cfg)
+	Emit(Event{Type: TypeStopped, RunID: "run-1", Metric: "loss", Value: &value, Threshold: &threshold}, cfg)
+	Emit(Event{Type: TypeError, Error: "connection refused"}, cfg)
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("streamed %d lines, want the stop and the error only:\n%s", len(lines), out.String())
+	}
+
+	var stop StreamEvent
+	if err := json.Unmarshal([]byte(lines[0]), &stop); err != nil {
+		t.Fatalf("failed to decode %q: %v", lines[0], err)
+	}
+	if stop.Type != TypeStopped || stop.RunID != "run-1" || stop.Metric != "loss" ||
+		stop.Value == nil || *stop.Value != 5 || stop.Threshold == nil || *stop.Threshold != 1 || stop.TS.IsZero() {
+		t.Errorf("stop event = %+v, want a timestamped stop of run-1 on loss", stop)
+	}
+	if !strings.Contains(lines[1], `"error":"connection refused"`) {
+		t.Errorf("error event = %s, want the error message", lines[1])
+	}
+}
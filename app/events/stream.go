@@ -0,0 +1,58 @@
+package events
+
+import (
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// StreamEvent is the line written to stdout for every stop, warning and
+// error when EventStream is set. It is kept flat and short for tools reading
+// the stream, unlike the webhook Event.
+type StreamEvent struct {
+	TS        time.Time `json:"ts"`
+	Type      string    `json:"type"`
+	RunID     string    `json:"run_id,omitempty"`
+	Metric    string    `json:"metric,omitempty"`
+	Value     *float64  `json:"value,omitempty"`
+	Threshold *float64  `json:"threshold,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// streamOut is a variable so tests can capture the stream. Writes are
+// serialized so concurrent run checks never interleave lines.
+var (
+	streamMu  sync.Mutex
+	streamOut io.Writer = os.Stdout
+)
+
+// streamed reports whether events of eventType go to the stream.
+func streamed(eventType string) bool {
+	return eventType == TypeStopped || eventType == TypeWarning || eventType == TypeError
+}
+
+// writeStream writes event as one line of newline-delimited JSON.
+func writeStream(event Event) {
+	line, err := json.Marshal(StreamEvent{
+		TS:        event.Time,
+		Type:      event.Type,
+		RunID:     event.RunID,
+		Metric:    event.Metric,
+		Value:     event.Value,
+		Threshold: event.Threshold,
+		Error:     event.Error,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("failed to marshal stream event")
+		return
+	}
+
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	if _, err := streamOut.Write(append(line, '\n')); err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("failed to write stream event")
+	}
+}
@@ -0,0 +1,233 @@
+// Package expression evaluates simple arithmetic over metric keys, such as
+// "train_loss - val_loss" or "(a + b) / 2".
+//
+// Expressions support +, -, *, /, unary minus, parentheses, numbers and
+// metric keys. A key is a run of letters, digits, '_' and '.', not starting
+// with a digit; keys holding other characters, e.g. "train/loss", are
+// written between backticks: `train/loss`.
+package expression
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed expression, safe for concurrent use.
+type Expression struct {
+	source string
+	root   node
+	keys   []string
+}
+
+type node interface {
+	eval(values map[string]float64) float64
+}
+
+type number float64
+
+type key string
+
+type unary struct {
+	operand node
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+func (n number) eval(map[string]float64) float64 { return float64(n) }
+
+func (k key) eval(values map[string]float64) float64 { return values[string(k)] }
+
+func (u unary) eval(values map[string]float64) float64 { return -u.operand.eval(values) }
+
+func (b binary) eval(values map[string]float64) float64 {
+	left, right := b.left.eval(values), b.right.eval(values)
+	switch b.op {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	}
+	return left / right
+}
+
+// Parse parses source into an Expression.
+func Parse(source string) (*Expression, error) {
+	p := &parser{src: source, keys: map[string]struct{}{}}
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	if p.skipSpace(); p.pos < len(p.src) {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q at offset %d", source, p.src[p.pos], p.pos)
+	}
+	if len(p.keys) == 0 {
+		return nil, fmt.Errorf("invalid expression %q: it references no metric", source)
+	}
+
+	keys := make([]string, 0, len(p.keys))
+	for k := range p.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return &Expression{source: source, root: root, keys: keys}, nil
+}
+
+// String returns the source the expression was parsed from.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Keys returns the sorted metric keys the expression references.
+func (e *Expression) Keys() []string {
+	return e.keys
+}
+
+// Eval evaluates the expression with the given metric values. Every key
+// returned by Keys must be present. Division by zero follows IEEE 754 and
+// yields Inf or NaN.
+func (e *Expression) Eval(values map[string]float64) (float64, error) {
+	var missing []string
+	for _, k := range e.keys {
+		if _, ok := values[k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		return 0, fmt.Errorf("metrics not logged: %s", strings.Join(missing, ", "))
+	}
+	return e.root.eval(values), nil
+}
+
+type parser struct {
+	src  string
+	pos  int
+	keys map[string]struct{}
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end of the source.
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek() == '-' {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{operand: operand}, nil
+	}
+	return p.parseOperand()
+}
+
+func (p *parser) parseOperand() (node, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, errors.New("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ')' at offset %d", p.pos)
+		}
+		p.pos++
+		return inner, nil
+	case c == '`':
+		end := strings.IndexByte(p.src[p.pos+1:], '`')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated metric key at offset %d", p.pos)
+		}
+		name := p.src[p.pos+1 : p.pos+1+end]
+		if name == "" {
+			return nil, fmt.Errorf("empty metric key at offset %d", p.pos)
+		}
+		p.pos += end + 2
+		return p.key(name), nil
+	case isDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", p.src[start:p.pos], start)
+		}
+		return number(value), nil
+	case isKeyStart(c):
+		start := p.pos
+		for p.pos < len(p.src) && (isKeyStart(p.src[p.pos]) || isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return p.key(p.src[start:p.pos]), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+}
+
+func (p *parser) key(name string) node {
+	p.keys[name] = struct{}{}
+	return key(name)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isKeyStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,63 @@
+package expression
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	values := map[string]float64{"train_loss": 0.2, "val_loss": 0.9, "train/acc": 0.5, "lr.scale": 4}
+
+	tests := []struct {
+		source string
+		want   float64
+	}{
+		{"val_loss - train_loss", 0.7},
+		{"val_loss - train_loss - 0.1", 0.6},
+		{"(train_loss + val_loss) / 2", 0.55},
+		{"train_loss + val_loss * 2", 2},
+		{"-train_loss", -0.2},
+		{"2 * -`train/acc`", -1},
+		{"lr.scale / 8", 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			e, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got, err := e.Eval(values)
+			if err != nil {
+				t.Fatalf("Eval() error = %v", err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsInvalidExpressions(t *testing.T) {
+	for _, source := range []string{"", "loss -", "(loss", "loss)", "loss $ 2", "1 + 2", "`loss", "1..2 + loss"} {
+		t.Run(source, func(t *testing.T) {
+			if _, err := Parse(source); err == nil {
+				t.Errorf("Parse(%q) succeeded, want an error", source)
+			}
+		})
+	}
+}
+
+func TestEvalRequiresEveryKey(t *testing.T) {
+	e, err := Parse("train_loss - val_loss")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if want := []string{"train_loss", "val_loss"}; !reflect.DeepEqual(e.Keys(), want) {
+		t.Errorf("Keys() = %v, want %v", e.Keys(), want)
+	}
+	if _, err := e.Eval(map[string]float64{"train_loss": 1}); err == nil {
+		t.Error("Eval() succeeded with val_loss missing, want an error")
+	}
+}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"io"
+)
+
+// Message is the body posted to the notification gateway, which delivers it
+// to the platforms behind Channel.
+type Message struct {
+	Channel  string         `json:"channel"`
+	Message  string         `json:"message"`
+	Severity types.Severity `json:"severity"`
+}
+
+// SendGatewayNotification hands message to the internal notification gateway.
+// The gateway holds the platform credentials, so none are needed here.
+func SendGatewayNotification(message string, severity types.Severity, config config.Config) error {
+	if config.NotificationGatewayURL == "" {
+		return fmt.Errorf("notification gateway URL is not configured")
+	}
+
+	payload, err := json.Marshal(Message{
+		Channel:  config.NotificationGatewayChannel,
+		Message:  message,
+		Severity: severity,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal gateway message: %v", err)
+	}
+
+	client, err := httpclient.For(config)
+	if err != nil {
+		return fmt.Errorf("failed to send gateway notification: %v", err)
+	}
+
+	resp, err := client.Post(config.NotificationGatewayURL, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send gateway notification: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("notification gateway returned status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	log.Info().Msg("successfully sent gateway notification")
+	return nil
+}
+
+func init() {
+	notifier.Register(Notifier{})
+}
+
+// Notifier sends the GATEWAY channel's notifications through the notification gateway.
+type Notifier struct{}
+
+func (Notifier) Name() string {
+	return "GATEWAY"
+}
+
+func (Notifier) Send(_ context.Context, message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	return SendGatewayNotification(message, severity, config)
+}
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"encoding/json"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendGatewayNotification(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"accepted", http.StatusAccepted, false},
+		{"rejected", http.StatusBadGateway, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Message
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("failed to decode message: %v", err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			cfg := config.Config{NotificationGatewayURL: server.URL, NotificationGatewayChannel: "ml-alerts"}
+			err := SendGatewayNotification("run stopped", types.SeverityCritical, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendGatewayNotification() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			want := Message{Channel: "ml-alerts", Message: "run stopped", Severity: types.SeverityCritical}
+			if got != want {
+				t.Errorf("message = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSendGatewayNotificationRequiresURL(t *testing.T) {
+	if err := SendGatewayNotification("hello", types.SeverityInfo, config.Config{}); err == nil {
+		t.Error("SendGatewayNotification() error = nil, want missing URL error")
+	}
+}
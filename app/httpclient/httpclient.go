@@ -0,0 +1,85 @@
+package httpclient
+
+import (
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/version"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// clients caches one *http.Client per proxy and User-Agent setting so
+// notification senders reuse connections instead of building a transport
+// per message.
+var clients sync.Map
+
+// clientKey identifies the settings a cached client was built for.
+type clientKey struct {
+	proxyURL  string
+	userAgent string
+}
+
+// NewTransport returns a clone of the default transport that routes through
+// Config.HTTPProxyURL when set, falling back to HTTP_PROXY/HTTPS_PROXY.
+func NewTransport(config config.Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if config.HTTPProxyURL != "" {
+		proxyURL, err := url.Parse(config.HTTPProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HTTP proxy URL: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return transport, nil
+}
+
+// For returns the shared client for the proxy and User-Agent configured in
+// config.
+func For(config config.Config) (*http.Client, error) {
+	key := clientKey{proxyURL: config.HTTPProxyURL, userAgent: UserAgent(config)}
+	if client, ok := clients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+
+	transport, err := NewTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, _ := clients.LoadOrStore(key, &http.Client{Transport: WithUserAgent(transport, config)})
+	return client.(*http.Client), nil
+}
+
+// UserAgent returns Config.UserAgent, or mlflow-autostop/<version> when it
+// is not set.
+func UserAgent(config config.Config) string {
+	if config.UserAgent != "" {
+		return config.UserAgent
+	}
+	return version.UserAgent()
+}
+
+// WithUserAgent wraps base so that requests without a User-Agent of their
+// own send UserAgent(config) instead of Go's default.
+func WithUserAgent(base http.RoundTripper, config config.Config) http.RoundTripper {
+	return &userAgentTransport{base: base, userAgent: UserAgent(config)}
+}
+
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+// RoundTrip sets the User-Agent on a copy of req, since a RoundTripper must
+// not modify the request it is given.
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,84 @@
+package httpclient
+
+import (
+	"github.com/gidra39/mlflow-autostop/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForRoutesThroughConfiguredProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	client, err := For(config.Config{HTTPProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatalf("For() error = %v", err)
+	}
+
+	resp, err := client.Get("http://mlflow.invalid/api/2.0/mlflow/runs/get")
+	if err != nil {
+		t.Fatalf("GET through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if proxied != "http://mlflow.invalid/api/2.0/mlflow/runs/get" {
+		t.Errorf("proxy saw %q, want the original request URL", proxied)
+	}
+}
+
+func TestForReusesClientPerProxy(t *testing.T) {
+	cfg := config.Config{HTTPProxyURL: "http://proxy.invalid:3128"}
+
+	first, err := For(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := For(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Error("expected the same client for the same proxy setting")
+	}
+}
+
+func TestForSetsUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"default", "", "mlflow-autostop/dev"},
+		{"override", "ml-platform/1.0", "ml-platform/1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.UserAgent()
+			}))
+			defer server.Close()
+
+			client, err := For(config.Config{UserAgent: tt.userAgent})
+			if err != nil {
+				t.Fatalf("For() error = %v", err)
+			}
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatalf("GET failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if got != tt.want {
+				t.Errorf("User-Agent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	kafkago "github.com/segmentio/kafka-go"
+	"sync"
+	"time"
+)
+
+// writeTimeout bounds a single produce call, including retries.
+const writeTimeout = 10 * time.Second
+
+// Event is the JSON value produced for every notification.
+type Event struct {
+	Time     time.Time         `json:"time"`
+	Severity types.Severity    `json:"severity"`
+	Message  string            `json:"message"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// messageWriter is the part of *kafkago.Writer the sink uses, so tests can
+// substitute a fake.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafkago.Message) error
+	Close() error
+}
+
+// producer is shared by every notification so that broker connections are
+// reused rather than opened per message.
+var (
+	mu       sync.Mutex
+	producer messageWriter
+)
+
+// Init creates the shared producer for KafkaBrokers and KafkaTopic. It must
+// be called before SendKafkaNotification and paired with Close.
+func Init(config config.Config) error {
+	if len(config.KafkaBrokers) == 0 || config.KafkaTopic == "" {
+		return errors.New("kafka brokers or topic is not configured")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if producer != nil {
+		return nil
+	}
+	producer = &kafkago.Writer{
+		Addr:         kafkago.TCP(config.KafkaBrokers...),
+		Topic:        config.KafkaTopic,
+		Balancer:     &kafkago.Hash{},
+		RequiredAcks: kafkago.RequireAll,
+		BatchTimeout: 10 * time.Millisecond,
+		WriteTimeout: writeTimeout,
+	}
+	return nil
+}
+
+// Close flushes and closes the shared producer, if any.
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if producer == nil {
+		return nil
+	}
+	err := producer.Close()
+	producer = nil
+	return err
+}
+
+// SendKafkaNotification produces message as a JSON Event. The run ID field is
+// used as the message key so every event of a run lands on one partition, in
+// order.
+func SendKafkaNotification(message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	mu.Lock()
+	writer := producer
+	mu.Unlock()
+	if writer == nil {
+		return errors.New("kafka producer is not initialized")
+	}
+
+	msg, err := newMessage(message, severity, fields)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	if err := writer.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("failed to produce Kafka event: %v", err)
+	}
+
+	log.Info().Str("topic", config.KafkaTopic).Msg("successfully produced Kafka event")
+	return nil
+}
+
+func newMessage(message string, severity types.Severity, fields []types.NotificationField) (kafkago.Message, error) {
+	event := Event{
+		Time:     time.Now().UTC(),
+		Severity: severity,
+		Message:  message,
+	}
+	if len(fields) > 0 {
+		event.Fields = map[string]string{}
+		for _, field := range fields {
+			event.Fields[field.Key] = field.Value
+		}
+	}
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return kafkago.Message{}, fmt.Errorf("failed to marshal kafka event: %v", err)
+	}
+
+	msg := kafkago.Message{Value: value}
+	if runID := types.LookupField(fields, types.FieldRunID); runID != "" {
+		msg.Key = []byte(runID)
+	}
+	return msg, nil
+}
+
+func init() {
+	notifier.Register(Notifier{})
+}
+
+// Notifier sends the KAFKA channel's notifications through Kafka.
+type Notifier struct{}
+
+func (Notifier) Name() string {
+	return "KAFKA"
+}
+
+func (Notifier) Send(_ context.Context, message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	return SendKafkaNotification(message, severity, config, fields...)
+}
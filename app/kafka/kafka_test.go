@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	kafkago "github.com/segmentio/kafka-go"
+	"testing"
+)
+
+type fakeWriter struct {
+	messages []kafkago.Message
+	closed   bool
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestSendKafkaNotification(t *testing.T) {
+	fake := &fakeWriter{}
+	producer = fake
+	t.Cleanup(func() { producer = nil })
+
+	fields := []types.NotificationField{
+		{Key: types.FieldRunID, Title: "Run ID", Value: "run-1"},
+		{Key: types.FieldMetric, Title: "Metric", Value: "loss"},
+	}
+	if err := SendKafkaNotification("stopping run-1", types.SeverityError, config.Config{KafkaTopic: "alerts"}, fields...); err != nil {
+		t.Fatalf("SendKafkaNotification() error = %v", err)
+	}
+
+	if len(fake.messages) != 1 {
+		t.Fatalf("produced %d messages, want 1", len(fake.messages))
+	}
+	msg := fake.messages[0]
+	if string(msg.Key) != "run-1" {
+		t.Errorf("key = %q, want the run ID", msg.Key)
+	}
+
+	var event Event
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		t.Fatalf("value is not a JSON event: %v", err)
+	}
+	if event.Message != "stopping run-1" || event.Severity != types.SeverityError || event.Fields["metric"] != "loss" {
+		t.Errorf("event = %+v, want the message, severity and fields", event)
+	}
+
+	if err := Close(); err != nil || !fake.closed {
+		t.Errorf("Close() error = %v, closed = %v, want the producer closed", err, fake.closed)
+	}
+}
+
+func TestSendKafkaNotificationRequiresInit(t *testing.T) {
+	if err := SendKafkaNotification("hello", types.SeverityInfo, config.Config{}); err == nil {
+		t.Error("SendKafkaNotification() without Init should fail")
+	}
+}
@@ -1,33 +1,243 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/messaging"
 	"github.com/gidra39/mlflow-autostop/mlflow"
-	"log"
+	"github.com/gidra39/mlflow-autostop/monitor"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/sentry"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
+const (
+	exitClean      = 0
+	exitError      = 1
+	exitRunStopped = 2
+)
+
+// sentryFlushTimeout bounds how long exiting waits for error reports.
+const sentryFlushTimeout = 5 * time.Second
+
 func main() {
-	configuration := config.LoadConfig(".env", "config.json", "config.yaml")
+	configPath := flag.String("config", "", "Config file path or s3:// / https:// URL (default: search for config.json, config.yaml)")
 	runID := flag.String("run-id", "", "MLflow run ID to monitor (optional)")
 	experimentID := flag.String("experiment-id", "", "MLflow experiment ID to monitor (optional)")
-	debug := flag.Bool("debug", false, "Enable debug logging")
+	experimentName := flag.String("experiment-name", "", "MLflow experiment name to monitor, resolved to its ID (optional)")
+	runNamePattern := flag.String("run-name-pattern", "", "Glob matched against run names across all experiments, e.g. 'sweep-2024-*' (optional)")
+	debug := flag.Bool("debug", false, "Enable debug logging, overriding LOG_LEVEL")
+	list := flag.Bool("list", false, "List the runs that would be monitored with their metrics and thresholds, then exit")
+	once := flag.Bool("once", false, "Check once and exit (0 = clean, 1 = error, 2 = run stopped)")
+	validate := flag.Bool("validate", false, "Validate the config, check the MLflow connection, then exit (0 = valid, 1 = invalid)")
+	validateNotify := flag.Bool("validate-notify", false, "With -validate, also send a test notification through every channel")
+	metricsOnly := flag.Bool("metrics-only", false, "Write a CSV report of every run in the experiment with its metrics and stop reason to REPORT_PATH, then exit")
+	testNotify := flag.String("test-notify", "", "Send this message through every configured channel, print the result per channel, then exit (0 = all delivered, 1 = a channel failed)")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration as JSON, with secrets redacted, then exit")
 	flag.Parse()
 
-	if *debug {
-		log.Println("Debug mode enabled - verbose logging activated")
+	var configuration config.Config
+	if *configPath != "" {
+		configuration = config.LoadConfigFile(".env", *configPath)
+	} else {
+		configuration = config.LoadConfig(".env", "config.json", "config.yaml")
+	}
+
+	configureLogging(configuration, *debug)
+
+	if *printConfig {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(configuration.Redacted()); err != nil {
+			log.Fatal().Err(err).Msg("failed to print config")
+		}
+		os.Exit(exitClean)
+	}
+
+	if *testNotify != "" {
+		os.Exit(testNotifyChannels(*testNotify, configuration))
+	}
+
+	if err := sentry.Init(configuration); err != nil {
+		log.Fatal().Err(err).Msg("failed to initialize Sentry")
+	}
+
+	m := monitor.New(configuration)
+	m.RunID = *runID
+	m.ExperimentID = *experimentID
+	m.ExperimentName = *experimentName
+	m.RunNamePattern = *runNamePattern
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *validate {
+		if err := m.Validate(*validateNotify); err != nil {
+			log.Error().Err(err).Msg("configuration is invalid")
+			exit(m, exitError)
+		}
+		log.Info().Msg("configuration is valid")
+		exit(m, exitClean)
+	}
+
+	if *list {
+		runs, err := m.ListRuns()
+		if err != nil {
+			fatal(m, err, "failed to list runs")
+		}
+		if err := mlflow.WriteRunTable(os.Stdout, runs, configuration); err != nil {
+			fatal(m, err, "failed to print runs")
+		}
+		exit(m, exitClean)
+	}
+
+	if *metricsOnly {
+		runs, err := m.Report()
+		if err != nil {
+			fatal(m, err, "failed to fetch runs for the report")
+		}
+		if err := writeReport(configuration.ReportPath, runs, configuration); err != nil {
+			fatal(m, err, "failed to write the report")
+		}
+		log.Info().Str("path", configuration.ReportPath).Int("runs", len(runs)).Msg("wrote report")
+		exit(m, exitClean)
+	}
+
+	if *once {
+		result, err := m.CheckOnce(ctx)
+		if err != nil {
+			fatal(m, err, "failed to check runs")
+		}
+		exit(m, exitCode(result))
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			mlflow.PollNow()
+		}
+	}()
+
+	result, err := m.Run(ctx)
+	if err != nil {
+		fatal(m, err, "failed to start monitoring")
 	}
+	if ctx.Err() != nil {
+		log.Info().Msg("received shutdown signal")
+		exit(m, exitClean)
+	}
+	exit(m, exitCode(result))
+}
+
+// exit closes the monitor before exiting with code. os.Exit skips deferred
+// calls, so notification channels and Sentry would otherwise not be flushed.
+func exit(m *monitor.Monitor, code int) {
+	if err := m.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to close monitor")
+	}
+	if !sentry.Flush(sentryFlushTimeout) {
+		log.Warn().Msg("timed out sending error reports to Sentry")
+	}
+	os.Exit(code)
+}
+
+// fatal logs and reports err, then exits with exitError.
+func fatal(m *monitor.Monitor, err error, msg string) {
+	log.Error().Err(err).Msg(msg)
+	sentry.CaptureException(fmt.Errorf("%s: %w", msg, err))
+	exit(m, exitError)
+}
+
+// testNotifyChannels sends message through every configured channel,
+// including those only an EXPERIMENT_CHANNELS entry uses, and prints a
+// table of the outcomes, returning the exit code.
+func testNotifyChannels(message string, configuration config.Config) int {
+	if err := messaging.Open(configuration); err != nil {
+		log.Error().Err(err).Msg("failed to open notification channels")
+		return exitError
+	}
+	defer func() {
+		if err := messaging.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close notification channels")
+		}
+	}()
+
+	code := exitClean
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANNEL\tRESULT")
+	for _, result := range messaging.SendToEach(message, configuration) {
+		outcome := "ok"
+		switch {
+		case errors.Is(result.Err, notifier.ErrSkipped):
+			outcome = "skipped: " + result.Err.Error()
+		case result.Err != nil:
+			outcome = "failed: " + result.Err.Error()
+			code = exitError
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", strings.ToLower(result.Channel), outcome)
+	}
+	if err := tw.Flush(); err != nil {
+		log.Error().Err(err).Msg("failed to print results")
+		return exitError
+	}
+	return code
+}
+
+// writeReport writes the metrics-only CSV report of runs to path.
+func writeReport(path string, runs []types.Run, configuration config.Config) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := mlflow.WriteReport(file, runs, configuration); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
 
-	log.Printf("Using MLflow tracking URI: %s", configuration.MLflowTrackingURI)
+// exitCode maps a poll result to the process exit code: 0 when clean, 1 on
+// connection errors and 2 when at least one run was stopped. A stop takes
+// precedence since it is the outcome scripts most need to react to.
+func exitCode(result mlflow.PollResult) int {
+	if result.Stopped > 0 {
+		return exitRunStopped
+	}
+	if result.Errors > 0 {
+		return exitError
+	}
+	return exitClean
+}
 
-	if *runID != "" {
-		log.Printf("Monitoring specific run ID: %s", *runID)
-		mlflow.MonitorSpecificRun(*runID, configuration, *debug)
-	} else if *experimentID != "" {
-		log.Printf("Monitoring active runs in experiment ID: %s", *experimentID)
-		mlflow.MonitorExperiment(*experimentID, configuration, *debug)
+// configureLogging selects the global zerolog writer and level. The console
+// writer is meant for humans; json emits newline-delimited JSON for log
+// collectors. The level comes from LOG_LEVEL; -debug overrides it.
+func configureLogging(configuration config.Config, debug bool) {
+	if configuration.LogFormat == "json" {
+		log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
 	} else {
-		log.Println("Monitoring all active runs")
-		mlflow.MonitorAllActiveRuns(configuration, *debug)
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	}
+
+	level, err := zerolog.ParseLevel(configuration.LogLevel)
+	if err != nil || level == zerolog.NoLevel {
+		level = zerolog.InfoLevel
+	}
+	if debug {
+		level = zerolog.DebugLevel
 	}
+	zerolog.SetGlobalLevel(level)
 }
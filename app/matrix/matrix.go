@@ -0,0 +1,96 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type RoomMessage struct {
+	MsgType string `json:"msgtype"`
+	Body    string `json:"body"`
+}
+
+// txnCounter makes transaction IDs unique within a process even when two
+// sends share a timestamp.
+var txnCounter atomic.Uint64
+
+// SendMatrixNotification posts message to the configured room. Matrix treats
+// a repeated transaction ID as a retry of the same event, so every send gets
+// a fresh one. Informational messages are sent as m.notice, which clients
+// show less prominently.
+func SendMatrixNotification(message string, severity types.Severity, config config.Config) error {
+	if config.MatrixHomeserver == "" || config.MatrixRoomID == "" || config.MatrixAccessToken == "" {
+		return fmt.Errorf("matrix homeserver, room ID or access token is not configured")
+	}
+
+	msgType := "m.text"
+	if severity == types.SeverityInfo {
+		msgType = "m.notice"
+	}
+
+	payload, err := json.Marshal(RoomMessage{MsgType: msgType, Body: message})
+	if err != nil {
+		return fmt.Errorf("failed to marshal matrix message: %v", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(config.MatrixHomeserver, "/"), url.PathEscape(config.MatrixRoomID), newTxnID())
+
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build Matrix request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+config.MatrixAccessToken)
+
+	client, err := httpclient.For(config)
+	if err != nil {
+		return fmt.Errorf("failed to send Matrix notification: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Matrix notification: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("matrix API returned status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	log.Info().Msg("successfully sent Matrix notification")
+	return nil
+}
+
+func newTxnID() string {
+	return fmt.Sprintf("autostop-%d-%d", time.Now().UnixNano(), txnCounter.Add(1))
+}
+
+func init() {
+	notifier.Register(Notifier{})
+}
+
+// Notifier sends the MATRIX channel's notifications through a Matrix room.
+type Notifier struct{}
+
+func (Notifier) Name() string {
+	return "MATRIX"
+}
+
+func (Notifier) Send(_ context.Context, message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	return SendMatrixNotification(message, severity, config)
+}
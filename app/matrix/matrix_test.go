@@ -0,0 +1,57 @@
+package matrix
+
+import (
+	"encoding/json"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendMatrixNotificationUsesFreshTransactionIDs(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("method = %s, want PUT", r.Method)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q, want bearer token", got)
+		}
+
+		var msg RoomMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("failed to decode message: %v", err)
+		}
+		if msg.MsgType != "m.text" || msg.Body != "stopping" {
+			t.Errorf("message = %+v, want m.text with the notification", msg)
+		}
+
+		paths = append(paths, r.URL.EscapedPath())
+		_, _ = w.Write([]byte(`{"event_id":"$1"}`))
+	}))
+	defer server.Close()
+
+	cfg := config.Config{
+		MatrixHomeserver:  server.URL + "/",
+		MatrixRoomID:      "!room:example.org",
+		MatrixAccessToken: "token",
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := SendMatrixNotification("stopping", types.SeverityError, cfg); err != nil {
+			t.Fatalf("SendMatrixNotification() error = %v", err)
+		}
+	}
+
+	prefix := "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/"
+	for _, path := range paths {
+		if !strings.HasPrefix(path, prefix) {
+			t.Errorf("path = %q, want prefix %q", path, prefix)
+		}
+	}
+	if len(paths) != 2 || paths[0] == paths[1] {
+		t.Errorf("paths = %v, want two sends with distinct transaction IDs", paths)
+	}
+}
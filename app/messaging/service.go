@@ -1,44 +1,268 @@
 package messaging
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/gidra39/mlflow-autostop/config"
-	"github.com/gidra39/mlflow-autostop/slack"
-	"github.com/gidra39/mlflow-autostop/telegram"
+	_ "github.com/gidra39/mlflow-autostop/gateway"
+	"github.com/gidra39/mlflow-autostop/kafka"
+	_ "github.com/gidra39/mlflow-autostop/matrix"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	_ "github.com/gidra39/mlflow-autostop/opsgenie"
+	_ "github.com/gidra39/mlflow-autostop/pagerduty"
+	_ "github.com/gidra39/mlflow-autostop/slack"
+	_ "github.com/gidra39/mlflow-autostop/teams"
+	_ "github.com/gidra39/mlflow-autostop/telegram"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"slices"
+	"sort"
 	"strings"
 )
 
 const (
-	ChannelTelegram = "TELEGRAM"
-	ChannelSlack    = "SLACK"
-	ChannelBoth     = "BOTH"
+	ChannelTelegram  = "TELEGRAM"
+	ChannelSlack     = "SLACK"
+	ChannelTeams     = "TEAMS"
+	ChannelPagerDuty = "PAGERDUTY"
+	ChannelOpsgenie  = "OPSGENIE"
+	ChannelMatrix    = "MATRIX"
+	ChannelKafka     = "KAFKA"
+	ChannelGateway   = "GATEWAY"
+	ChannelBoth      = "BOTH"
 )
 
-func SendNotification(message string, config config.Config) error {
-	channels := strings.ToUpper(config.MessageChannels)
-	if channels == "" {
-		channels = ChannelTelegram
+// Channels parses a MESSAGE_CHANNELS value: a comma-separated list of
+// channel names, where BOTH is kept as shorthand for TELEGRAM,SLACK.
+func Channels(spec string) []string {
+	if strings.TrimSpace(spec) == "" {
+		return []string{ChannelTelegram}
 	}
 
-	var telegramErr, slackErr error
+	var channels []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		expanded := []string{name}
+		if name == ChannelBoth {
+			expanded = []string{ChannelTelegram, ChannelSlack}
+		}
+
+		for _, channel := range expanded {
+			if channel != "" && !seen[channel] {
+				seen[channel] = true
+				channels = append(channels, channel)
+			}
+		}
+	}
+	return channels
+}
+
+// ValidateChannels checks that every channel in MessageChannels and
+// ExperimentChannels is known and has the settings it needs, so a
+// misconfigured channel fails at startup rather than silently dropping the
+// first stop notification.
+func ValidateChannels(config config.Config) error {
+	var errs []error
+	checked := map[string]bool{}
+	for _, spec := range channelSpecs(config) {
+		for _, channel := range Channels(spec.value) {
+			if _, known := notifier.Lookup(channel); !known {
+				errs = append(errs, fmt.Errorf("unknown notification channel %q in %s", channel, spec.key))
+				continue
+			}
+			if checked[channel] {
+				continue
+			}
+			checked[channel] = true
+
+			for _, setting := range requiredSettings(channel, config) {
+				if strings.TrimSpace(setting.value) == "" {
+					errs = append(errs, fmt.Errorf("channel %s requires %s to be set", channel, setting.key))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// channelSpecs returns MessageChannels followed by the ExperimentChannels
+// entries in experiment ID order, each with the config key it was set under.
+func channelSpecs(config config.Config) []setting {
+	specs := []setting{{"MESSAGE_CHANNELS", config.MessageChannels}}
+	experimentIDs := make([]string, 0, len(config.ExperimentChannels))
+	for experimentID := range config.ExperimentChannels {
+		experimentIDs = append(experimentIDs, experimentID)
+	}
+	sort.Strings(experimentIDs)
+	for _, experimentID := range experimentIDs {
+		specs = append(specs, setting{"EXPERIMENT_CHANNELS." + experimentID, config.ExperimentChannels[experimentID]})
+	}
+	return specs
+}
+
+// setting is a config value together with its config key name.
+type setting struct {
+	key   string
+	value string
+}
+
+// requiredSettings returns the config values channel cannot work without.
+func requiredSettings(channel string, config config.Config) []setting {
+	switch channel {
+	case ChannelTelegram:
+		return []setting{
+			{"TELEGRAM_BOT_TOKEN", config.TelegramBotToken},
+			{"TELEGRAM_CHAT_ID", config.TelegramChatID},
+		}
+	case ChannelSlack:
+		return []setting{{"SLACK_WEBHOOK_URL", config.SlackWebhookURL}}
+	case ChannelTeams:
+		return []setting{{"TEAMS_WEBHOOK_URL", config.TeamsWebhookURL}}
+	case ChannelPagerDuty:
+		return []setting{{"PAGERDUTY_ROUTING_KEY", config.PagerDutyRoutingKey}}
+	case ChannelOpsgenie:
+		return []setting{{"OPSGENIE_API_KEY", config.OpsgenieAPIKey}}
+	case ChannelMatrix:
+		return []setting{
+			{"MATRIX_HOMESERVER", config.MatrixHomeserver},
+			{"MATRIX_ROOM_ID", config.MatrixRoomID},
+			{"MATRIX_ACCESS_TOKEN", config.MatrixAccessToken},
+		}
+	case ChannelKafka:
+		return []setting{
+			{"KAFKA_BROKERS", strings.Join(config.KafkaBrokers, ",")},
+			{"KAFKA_TOPIC", config.KafkaTopic},
+		}
+	case ChannelGateway:
+		return []setting{{"NOTIFICATION_GATEWAY_URL", config.NotificationGatewayURL}}
+	}
+	return nil
+}
+
+// Open prepares the channels that hold long-lived connections, including
+// those only an ExperimentChannels entry uses. It is called once at startup,
+// after ValidateChannels, and paired with Close.
+func Open(config config.Config) error {
+	if usesChannel(config, ChannelKafka) {
+		if err := kafka.Init(config); err != nil {
+			return fmt.Errorf("kafka: %v", err)
+		}
+	}
+	return nil
+}
+
+// usesChannel reports whether channel appears in MessageChannels or any
+// ExperimentChannels entry.
+func usesChannel(config config.Config, channel string) bool {
+	for _, spec := range channelSpecs(config) {
+		if slices.Contains(Channels(spec.value), channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// allChannels returns the channels of MessageChannels and every
+// ExperimentChannels entry without duplicates, in channelSpecs order.
+func allChannels(config config.Config) []string {
+	var channels []string
+	for _, spec := range channelSpecs(config) {
+		for _, channel := range Channels(spec.value) {
+			if !slices.Contains(channels, channel) {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	return channels
+}
+
+// Close releases the connections opened by Open, flushing pending messages.
+func Close() error {
+	if err := kafka.Close(); err != nil {
+		return fmt.Errorf("kafka: %v", err)
+	}
+	return nil
+}
+
+// CheckChannels sends a test message through every configured channel and
+// returns the errors of the channels that failed, each prefixed with the
+// channel name. Channels that skip the message are not failures.
+func CheckChannels(config config.Config) error {
+	message := "✅ mlflow-autostop test notification: this channel is configured correctly"
 
-	if channels == ChannelTelegram || channels == ChannelBoth {
-		telegramErr = telegram.SendTelegramNotification(message, config)
+	var errs []error
+	for _, result := range SendToEach(message, config) {
+		if result.Err != nil && !errors.Is(result.Err, notifier.ErrSkipped) {
+			errs = append(errs, fmt.Errorf("%s: %v", strings.ToLower(result.Channel), result.Err))
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	if channels == ChannelSlack || channels == ChannelBoth {
-		slackErr = slack.SendSlackNotification(message, config)
+// ChannelResult is the outcome of sending through one channel; Err is nil
+// when the message was delivered.
+type ChannelResult struct {
+	Channel string
+	Err     error
+}
+
+// SendToEach sends message through every configured channel, those of
+// MessageChannels and of any ExperimentChannels entry, once each. The
+// channels are tried one after the other regardless of earlier failures,
+// and the outcome of each is reported.
+func SendToEach(message string, config config.Config) []ChannelResult {
+	var results []ChannelResult
+	for _, channel := range allChannels(config) {
+		results = append(results, ChannelResult{
+			Channel: channel,
+			Err:     send(channel, message, types.SeverityInfo, config, nil),
+		})
 	}
+	return results
+}
 
-	if channels == ChannelBoth {
-		if telegramErr != nil && slackErr != nil {
-			return telegramErr
+// SendNotification delivers message through the configured channels. severity
+// is passed to every channel, which may route or format on it. The optional
+// fields carry structured details for channels that can render them.
+// Every channel is attempted even when an earlier one fails, so one
+// misconfigured channel does not silence the others. The combined error of
+// the failed and skipped channels is returned only when no channel delivered
+// the message; otherwise the failures are logged. A channel that skips the
+// message (notifier.ErrSkipped) is neither a failure nor a delivery.
+func SendNotification(message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	channels := Channels(config.MessageChannels)
+
+	var errs, skipped []error
+	for _, channel := range channels {
+		err := send(channel, message, severity, config, fields)
+		switch {
+		case err == nil:
+		case errors.Is(err, notifier.ErrSkipped):
+			skipped = append(skipped, fmt.Errorf("%s: %w", strings.ToLower(channel), err))
+		default:
+			errs = append(errs, fmt.Errorf("%s: %v", strings.ToLower(channel), err))
 		}
-		return nil
-	} else if channels == ChannelTelegram {
-		return telegramErr
-	} else if channels == ChannelSlack {
-		return slackErr
 	}
 
+	if len(errs)+len(skipped) == len(channels) {
+		return errors.Join(append(errs, skipped...)...)
+	}
+	if len(errs) > 0 {
+		log.Error().Err(errors.Join(errs...)).Int("failed", len(errs)).Int("channels", len(channels)).
+			Msg("some notification channels failed, the message was delivered through the others")
+	}
 	return nil
 }
+
+// send delivers message through the notifier registered for channel. The
+// channel packages register themselves from init, which the blank imports
+// above guarantee has run.
+func send(channel string, message string, severity types.Severity, config config.Config, fields []types.NotificationField) error {
+	n, ok := notifier.Lookup(channel)
+	if !ok {
+		return fmt.Errorf("unknown notification channel %q", channel)
+	}
+	return n.Send(context.Background(), message, severity, config, fields...)
+}
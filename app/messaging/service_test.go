@@ -0,0 +1,150 @@
+package messaging
+
+import (
+	"errors"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChannels(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"", []string{ChannelTelegram}},
+		{"slack", []string{ChannelSlack}},
+		{"BOTH", []string{ChannelTelegram, ChannelSlack}},
+		{"teams, slack", []string{ChannelTeams, ChannelSlack}},
+		{"BOTH,SLACK,TEAMS", []string{ChannelTelegram, ChannelSlack, ChannelTeams}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got := Channels(tt.spec)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Channels(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateChannels(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  config.Config
+		wantErr string
+	}{
+		{"telegram configured", config.Config{MessageChannels: "TELEGRAM", TelegramBotToken: "token", TelegramChatID: "123"}, ""},
+		{"telegram without chat id", config.Config{MessageChannels: "TELEGRAM", TelegramBotToken: "token"}, "TELEGRAM_CHAT_ID"},
+		{"both without slack webhook", config.Config{MessageChannels: "BOTH", TelegramBotToken: "token", TelegramChatID: "123"}, "SLACK_WEBHOOK_URL"},
+		{"pagerduty without key", config.Config{MessageChannels: "PAGERDUTY"}, "PAGERDUTY_ROUTING_KEY"},
+		{"kafka without topic", config.Config{MessageChannels: "KAFKA", KafkaBrokers: []string{"localhost:9092"}}, "KAFKA_TOPIC"},
+		{"unknown channel", config.Config{MessageChannels: "CARRIER_PIGEON"}, "unknown notification channel"},
+		{"experiment channel without slack webhook", config.Config{
+			MessageChannels: "TELEGRAM", TelegramBotToken: "token", TelegramChatID: "123",
+			ExperimentChannels: map[string]string{"7": "SLACK"},
+		}, "SLACK_WEBHOOK_URL"},
+		{"unknown experiment channel", config.Config{
+			MessageChannels: "TELEGRAM", TelegramBotToken: "token", TelegramChatID: "123",
+			ExperimentChannels: map[string]string{"7": "CARRIER_PIGEON"},
+		}, "EXPERIMENT_CHANNELS.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateChannels(tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateChannels() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateChannels() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEveryChannelHasANotifier(t *testing.T) {
+	channels := []string{ChannelTelegram, ChannelSlack, ChannelTeams, ChannelPagerDuty,
+		ChannelOpsgenie, ChannelMatrix, ChannelKafka, ChannelGateway}
+
+	for _, channel := range channels {
+		if _, ok := notifier.Lookup(channel); !ok {
+			t.Errorf("no notifier registered for %s, registered: %v", channel, notifier.Names())
+		}
+	}
+}
+
+func TestSendNotificationContinuesPastFailingChannel(t *testing.T) {
+	slackCalls := 0
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slackCalls++
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer slackServer.Close()
+
+	// Telegram comes first and has no credentials.
+	cfg := config.Config{MessageChannels: "TELEGRAM,SLACK", SlackWebhookURL: slackServer.URL}
+
+	if err := SendNotification("run stopped", types.SeverityError, cfg); err != nil {
+		t.Errorf("SendNotification() error = %v, want nil since Slack delivered", err)
+	}
+	if slackCalls != 1 {
+		t.Errorf("Slack was called %d times, want once despite the broken Telegram config", slackCalls)
+	}
+
+	cfg.SlackWebhookURL = ""
+	err := SendNotification("run stopped", types.SeverityError, cfg)
+	if err == nil || !strings.Contains(err.Error(), "telegram") || !strings.Contains(err.Error(), "slack") {
+		t.Errorf("SendNotification() error = %v, want the errors of both channels", err)
+	}
+}
+
+func TestSendNotificationSkippedIsNotDelivered(t *testing.T) {
+	cfg := config.Config{MessageChannels: "PAGERDUTY", PagerDutyRoutingKey: "key", PagerDutyOnlyCritical: true}
+
+	err := SendNotification("run stopped", types.SeverityError, cfg)
+	if !errors.Is(err, notifier.ErrSkipped) {
+		t.Errorf("SendNotification() error = %v, want notifier.ErrSkipped when the only channel skipped", err)
+	}
+}
+
+func TestSendToEachReportsEveryChannel(t *testing.T) {
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer slackServer.Close()
+
+	cfg := config.Config{MessageChannels: "TELEGRAM,SLACK", SlackWebhookURL: slackServer.URL}
+	results := SendToEach("hello", cfg)
+
+	if len(results) != 2 || results[0].Channel != ChannelTelegram || results[1].Channel != ChannelSlack {
+		t.Fatalf("SendToEach() = %+v, want one result per channel in order", results)
+	}
+	if results[0].Err == nil || results[1].Err != nil {
+		t.Errorf("SendToEach() = %+v, want Telegram failed and Slack delivered", results)
+	}
+}
+
+func TestSendToEachCoversExperimentChannels(t *testing.T) {
+	cfg := config.Config{
+		MessageChannels:    "TELEGRAM,SLACK",
+		ExperimentChannels: map[string]string{"7": "SLACK,TEAMS", "3": "PAGERDUTY"},
+	}
+
+	var got []string
+	for _, result := range SendToEach("hello", cfg) {
+		got = append(got, result.Channel)
+	}
+	want := []string{ChannelTelegram, ChannelSlack, ChannelPagerDuty, ChannelTeams}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("SendToEach() channels = %v, want %v", got, want)
+	}
+}
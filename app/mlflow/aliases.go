@@ -0,0 +1,76 @@
+package mlflow
+
+import (
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"sort"
+)
+
+// resolveAliases returns run with each metric logged under a MetricAliases
+// alias renamed to its canonical key, so the rules for the canonical key
+// apply to it. An alias is ignored when the run also logs the canonical key,
+// and when it logs several aliases of one key the first listed wins. Config
+// validation rejects an alias listed under two keys, and the keys are visited
+// in sorted order, so the outcome never depends on map iteration.
+// loggedKeys maps every renamed key back to the key the run logged it under.
+func resolveAliases(run types.Run, config config.Config) (resolved types.Run, loggedKeys map[string]string) {
+	if len(config.MetricAliases) == 0 {
+		return run, nil
+	}
+
+	logged := make(map[string]bool, len(run.Data.Metrics))
+	for _, metric := range run.Data.Metrics {
+		logged[metric.Key] = true
+	}
+
+	keys := make([]string, 0, len(config.MetricAliases))
+	for key := range config.MetricAliases {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	canonical := make(map[string]string)
+	for _, key := range keys {
+		if logged[key] {
+			continue
+		}
+		for _, alias := range config.MetricAliases[key] {
+			if _, taken := canonical[alias]; logged[alias] && !taken {
+				canonical[alias] = key
+				break
+			}
+		}
+	}
+	if len(canonical) == 0 {
+		return run, nil
+	}
+
+	loggedKeys = make(map[string]string, len(canonical))
+	metrics := make([]types.Metric, len(run.Data.Metrics))
+	for i, metric := range run.Data.Metrics {
+		if key, aliased := canonical[metric.Key]; aliased {
+			log.Debug().Str("run_id", run.Info.RunID).Str("metric", key).Str("alias", metric.Key).
+				Msg("evaluating metric alias as its canonical key")
+			loggedKeys[key] = metric.Key
+			metric.Key = key
+		}
+		metrics[i] = metric
+	}
+	run.Data.Metrics = metrics
+	return run, loggedKeys
+}
+
+// aliasedClient fetches the history of a metric renamed by resolveAliases
+// under the key the run logged it as.
+type aliasedClient struct {
+	MLflowClient
+	loggedKeys map[string]string
+}
+
+func (c aliasedClient) GetMetricHistory(runID string, metricKey string) (*types.GetMetricHistoryResponse, error) {
+	if logged, aliased := c.loggedKeys[metricKey]; aliased {
+		metricKey = logged
+	}
+	return c.MLflowClient.GetMetricHistory(runID, metricKey)
+}
@@ -0,0 +1,51 @@
+package mlflow
+
+import (
+	"github.com/gidra39/mlflow-autostop/audit"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"math"
+	"time"
+)
+
+// auditLog records every stop decision when AuditLogPath is set.
+var auditLog *audit.Log
+
+// SetAuditLog installs the audit log stop decisions are appended to.
+func SetAuditLog(l *audit.Log) {
+	auditLog = l
+}
+
+// recordStop appends the decision to stop run because of v, along with the
+// outcome of the stop call, to the audit log.
+func recordStop(run types.RunInfo, v violation, stopErr error) {
+	if auditLog == nil {
+		return
+	}
+
+	entry := audit.Entry{
+		Timestamp:    time.Now().UTC(),
+		RunID:        run.RunID,
+		ExperimentID: run.ExperimentID,
+		User:         run.UserID,
+		Metric:       v.Metric,
+		Value:        finiteOrNil(v.Value),
+		Threshold:    finiteOrNil(v.Threshold),
+		Reason:       v.Reason,
+		Stopped:      stopErr == nil,
+	}
+	if stopErr != nil {
+		entry.Error = stopErr.Error()
+	}
+
+	if err := auditLog.Record(entry); err != nil {
+		log.Error().Err(err).Str("run_id", run.RunID).Msg("failed to write audit log")
+	}
+}
+
+func finiteOrNil(value float64) *float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,103 @@
+package mlflow
+
+import (
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"math"
+	"sync"
+	"time"
+)
+
+// baselineCache holds the latest metrics of the configured baseline run so
+// that runs/get is called once per refresh interval rather than per run.
+type baselineCache struct {
+	mu      sync.Mutex
+	runID   string
+	metrics map[string]float64
+	fetched time.Time
+}
+
+// baselines holds a cache per tracking server, since the same run ID may
+// name a different run, or none, on each of them.
+var baselines sync.Map // tracking URI -> *baselineCache
+
+// baselineMetrics returns the cached metrics of config.BaselineRunID on the
+// server config points at, refreshing them once BaselineRefreshSeconds have
+// passed. A failed refresh keeps serving the previous values.
+func baselineMetrics(client MLflowClient, config config.Config) map[string]float64 {
+	if config.BaselineRunID == "" {
+		return nil
+	}
+
+	cached, _ := baselines.LoadOrStore(config.MLflowTrackingURI, &baselineCache{})
+	baseline := cached.(*baselineCache)
+	baseline.mu.Lock()
+	defer baseline.mu.Unlock()
+
+	refresh := time.Duration(config.BaselineRefreshSeconds) * time.Second
+	if baseline.runID == config.BaselineRunID && baseline.metrics != nil &&
+		(refresh <= 0 || time.Since(baseline.fetched) < refresh) {
+		return baseline.metrics
+	}
+
+	run, err := client.GetRun(config.BaselineRunID)
+	if err != nil {
+		errorEvent(err).Str("run_id", config.BaselineRunID).Msg("failed to fetch baseline run")
+		if baseline.runID == config.BaselineRunID {
+			return baseline.metrics
+		}
+		return nil
+	}
+
+	metrics := make(map[string]float64, len(run.Run.Data.Metrics))
+	for _, metric := range run.Run.Data.Metrics {
+		if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+			continue
+		}
+		metrics[metric.Key] = metric.Value
+	}
+
+	baseline.runID = config.BaselineRunID
+	baseline.metrics = metrics
+	baseline.fetched = time.Now()
+	log.Debug().Str("run_id", config.BaselineRunID).Int("metrics", len(metrics)).Msg("refreshed baseline metrics")
+	return metrics
+}
+
+// baselineViolation reports whether metric is more than RelativeTolerancePct
+// worse than the baseline's value for the same key. Which way is worse comes
+// from the metric's threshold, as for MaxRegressionPct; metrics without a
+// one-sided threshold are not compared.
+func baselineViolation(run types.RunInfo, metric types.Metric, metrics map[string]float64, config config.Config) (violation, bool) {
+	v := newViolation(run, metric)
+
+	reference, exists := metrics[metric.Key]
+	if !exists || run.RunID == config.BaselineRunID {
+		return v, false
+	}
+	higherIsBetter, ok := metricDirection(metric.Key, config)
+	if !ok {
+		return v, false
+	}
+
+	margin := math.Abs(reference) * config.RelativeTolerancePct / 100
+	if higherIsBetter {
+		v.Threshold = reference - margin
+		if metric.Value >= v.Threshold {
+			return v, false
+		}
+		v.Reason = fmt.Sprintf("Metric %s = %.4f fell more than %.1f%% below baseline run %s (%.4f)",
+			metric.Key, metric.Value, config.RelativeTolerancePct, config.BaselineRunID, reference)
+		return v, true
+	}
+
+	v.Threshold = reference + margin
+	if metric.Value <= v.Threshold {
+		return v, false
+	}
+	v.Reason = fmt.Sprintf("Metric %s = %.4f rose more than %.1f%% above baseline run %s (%.4f)",
+		metric.Key, metric.Value, config.RelativeTolerancePct, config.BaselineRunID, reference)
+	return v, true
+}
@@ -0,0 +1,87 @@
+package mlflow
+
+import (
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"testing"
+)
+
+func TestBaselineViolation(t *testing.T) {
+	accuracyFloor, lossCeiling, rewardFloor := 0.1, 10.0, -1000.0
+	cfg := config.Config{
+		BaselineRunID:        "base",
+		RelativeTolerancePct: 10,
+		MetricThresholds: config.Thresholds{
+			"accuracy": {Min: &accuracyFloor},
+			"val_loss": {Max: &lossCeiling},
+			"reward":   {Min: &rewardFloor},
+		},
+	}
+	reference := map[string]float64{"accuracy": 0.9, "val_loss": 0.5, "reward": -100, "lr": 0.01}
+
+	tests := []struct {
+		name   string
+		runID  string
+		metric types.Metric
+		want   bool
+	}{
+		{name: "within tolerance", runID: "r1", metric: types.Metric{Key: "accuracy", Value: 0.82}, want: false},
+		{name: "below tolerance", runID: "r1", metric: types.Metric{Key: "accuracy", Value: 0.8}, want: true},
+		{name: "metric not in baseline", runID: "r1", metric: types.Metric{Key: "loss", Value: 0.1}, want: false},
+		{name: "baseline run itself", runID: "base", metric: types.Metric{Key: "accuracy", Value: 0.1}, want: false},
+		{name: "lower is better improves on baseline", runID: "r1", metric: types.Metric{Key: "val_loss", Value: 0.2}, want: false},
+		{name: "lower is better past tolerance", runID: "r1", metric: types.Metric{Key: "val_loss", Value: 0.6}, want: true},
+		{name: "negative reference within tolerance", runID: "r1", metric: types.Metric{Key: "reward", Value: -105}, want: false},
+		{name: "negative reference below tolerance", runID: "r1", metric: types.Metric{Key: "reward", Value: -115}, want: true},
+		{name: "metric without direction", runID: "r1", metric: types.Metric{Key: "lr", Value: 1}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := baselineViolation(types.RunInfo{RunID: tt.runID}, tt.metric, reference, cfg)
+			if got != tt.want {
+				t.Errorf("baselineViolation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBaselineMetricsCachesRun(t *testing.T) {
+	stub := newStubMLflow(t, runningRun("base", types.Metric{Key: "accuracy", Value: 0.9}))
+	cfg := stub.config(nil)
+	cfg.BaselineRunID = "base"
+	cfg.BaselineRefreshSeconds = 300
+	client := newTestClient(t, cfg)
+
+	for i := 0; i < 3; i++ {
+		metrics := baselineMetrics(client, cfg)
+		if metrics["accuracy"] != 0.9 {
+			t.Fatalf("baselineMetrics() = %v, want accuracy 0.9", metrics)
+		}
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.gets != 1 {
+		t.Errorf("runs/get called %d times, want 1", stub.gets)
+	}
+}
+
+func TestBaselineMetricsPerServer(t *testing.T) {
+	first := newStubMLflow(t, runningRun("base", types.Metric{Key: "accuracy", Value: 0.9}))
+	second := newStubMLflow(t, runningRun("base", types.Metric{Key: "accuracy", Value: 0.5}))
+
+	for _, tt := range []struct {
+		stub *stubMLflow
+		want float64
+	}{{first, 0.9}, {second, 0.5}, {first, 0.9}} {
+		cfg := tt.stub.config(nil)
+		cfg.BaselineRunID = "base"
+		cfg.BaselineRefreshSeconds = 300
+
+		metrics := baselineMetrics(newTestClient(t, cfg), cfg)
+		if metrics["accuracy"] != tt.want {
+			t.Errorf("baselineMetrics(%s) = %v, want accuracy %v", tt.stub.URL, metrics, tt.want)
+		}
+	}
+}
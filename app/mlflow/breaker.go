@@ -0,0 +1,92 @@
+package mlflow
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/sentry"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of calling MLflow while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("MLflow circuit breaker is open, skipping request")
+
+// circuitBreaker stops calls to MLflow after threshold consecutive failures.
+// Once cooldown has passed it lets a single trial request through
+// (half-open); success closes the circuit, failure reopens it for another
+// cooldown. A nil breaker allows every call.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	trial     bool
+	openUntil time.Time
+	now       func() time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow returns ErrCircuitOpen while the circuit is open and no trial
+// request is due.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return nil
+	}
+	if b.trial || b.now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+
+	b.trial = true
+	log.Info().Msg("MLflow circuit half-open, sending a trial request")
+	return nil
+}
+
+// record updates the breaker with the outcome of a call that allow let
+// through.
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		if b.open {
+			log.Info().Msg("MLflow circuit closed, requests resumed")
+		}
+		b.failures = 0
+		b.open = false
+		b.trial = false
+		return
+	}
+
+	b.failures++
+	if b.open || b.failures >= b.threshold {
+		if !b.open {
+			log.Error().Int("failures", b.failures).Dur("cooldown", b.cooldown).
+				Msg("MLflow circuit open, pausing requests")
+			sentry.CaptureException(fmt.Errorf("MLflow unreachable after %d consecutive failures", b.failures))
+		}
+		b.open = true
+		b.trial = false
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
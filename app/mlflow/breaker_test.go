@@ -0,0 +1,77 @@
+package mlflow
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	breaker := newCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.record(true)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("one failure should not open the circuit: %v", err)
+	}
+
+	breaker.record(true)
+	if err := breaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow() = %v, want ErrCircuitOpen after reaching the threshold", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("allow() = %v, want a trial request after the cooldown", err)
+	}
+	if err := breaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow() = %v, want only one trial request while half-open", err)
+	}
+
+	breaker.record(true)
+	if err := breaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow() = %v, want a failed trial to reopen the circuit", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := breaker.allow(); err != nil {
+		t.Fatal(err)
+	}
+	breaker.record(false)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("allow() = %v, want a successful trial to close the circuit", err)
+	}
+}
+
+func TestNilCircuitBreakerAllowsEverything(t *testing.T) {
+	var breaker *circuitBreaker = newCircuitBreaker(0, time.Minute)
+	breaker.record(true)
+	if err := breaker.allow(); err != nil {
+		t.Errorf("disabled breaker returned %v", err)
+	}
+}
+
+func TestUnbuildableRequestDoesNotTakeTrial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]interface{}{})
+	}))
+	defer server.Close()
+
+	now := time.Unix(0, 0)
+	breaker := newCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+	client := &httpMLflowClient{baseURL: server.URL, httpClient: server.Client(), breaker: breaker}
+
+	breaker.record(true)
+	now = now.Add(time.Minute)
+
+	if err := client.do(http.MethodPost, server.URL, 0, func() {}, nil); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("do() with an unmarshalable body = %v, want a marshal error", err)
+	}
+	if err := client.do(http.MethodGet, server.URL, 0, nil, nil); err != nil {
+		t.Errorf("do() = %v, want the trial request still available after the failed build", err)
+	}
+}
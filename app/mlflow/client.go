@@ -0,0 +1,319 @@
+package mlflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultAPIBasePath is the REST prefix of the MLflow 2.x tracking API.
+const defaultAPIBasePath = "/api/2.0/mlflow"
+
+// MLflowClient is the subset of the MLflow tracking REST API the monitor uses.
+// The monitor functions depend on this interface so tests can inject a fake.
+type MLflowClient interface {
+	GetRun(runID string) (*types.GetRunResponse, error)
+	SearchRuns(request types.SearchRunsRequest) (*types.GetRunsResponse, error)
+	GetMetricHistory(runID string, metricKey string) (*types.GetMetricHistoryResponse, error)
+	GetExperimentByName(name string) (*types.GetExperimentResponse, error)
+	UpdateRun(runID string, status string) error
+	DeleteRun(runID string) error
+	SetTag(runID string, key string, value string) error
+	LogMetric(runID string, key string, value float64, timestamp int64, step int) error
+	Ping() error
+}
+
+// httpMLflowClient bounds each request by its own timeout: readTimeout
+// (SearchTimeoutSeconds) for searches and lookups, which may be slow on large
+// experiments, and mutationTimeout (MutationTimeoutSeconds) for calls that
+// change a run, which should be fast. A zero timeout leaves the request
+// unbounded. username and password come from the userinfo of the tracking
+// URI, which baseURL no longer carries.
+type httpMLflowClient struct {
+	baseURL         string
+	apiBasePath     string
+	token           string
+	username        string
+	password        string
+	httpClient      *http.Client
+	readTimeout     time.Duration
+	mutationTimeout time.Duration
+	breaker         *circuitBreaker
+}
+
+// NewClient returns an MLflowClient talking to the configured tracking server.
+func NewClient(config config.Config) (MLflowClient, error) {
+	httpClient, err := newHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	breaker := newCircuitBreaker(config.CircuitBreakerThreshold,
+		time.Duration(config.CircuitBreakerCooldownSeconds)*time.Second)
+
+	// The OAuth transport sets the Authorization header itself; the static
+	// token is only the fallback.
+	token := config.MLflowTrackingToken
+	if config.OAuthTokenURL != "" {
+		token = ""
+	}
+
+	baseURL, username, password := config.SplitTrackingURI()
+
+	return &httpMLflowClient{
+		baseURL:         baseURL,
+		apiBasePath:     normalizeBasePath(config.MLflowAPIBasePath),
+		token:           token,
+		username:        username,
+		password:        password,
+		httpClient:      httpClient,
+		readTimeout:     time.Duration(config.SearchTimeoutSeconds) * time.Second,
+		mutationTimeout: time.Duration(config.MutationTimeoutSeconds) * time.Second,
+		breaker:         breaker,
+	}, nil
+}
+
+// normalizeBasePath gives path a single leading slash and no trailing one,
+// falling back to the MLflow 2.x REST prefix when it is empty.
+func normalizeBasePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return defaultAPIBasePath
+	}
+	return "/" + path
+}
+
+// buildEndpoint returns the URL of the REST endpoint at path, which may carry
+// a query string, below the configured API base path.
+func (c *httpMLflowClient) buildEndpoint(path string) string {
+	return c.baseURL + c.apiBasePath + "/" + path
+}
+
+func (c *httpMLflowClient) GetRun(runID string) (*types.GetRunResponse, error) {
+	endpoint := c.buildEndpoint("runs/get?run_id=" + url.QueryEscape(runID))
+
+	var runResponse types.GetRunResponse
+	if err := c.do(http.MethodGet, endpoint, c.readTimeout, nil, &runResponse); err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: %s", ErrRunNotFound, runID)
+		}
+		return nil, fmt.Errorf("failed to fetch run details: %w", err)
+	}
+
+	return &runResponse, nil
+}
+
+func (c *httpMLflowClient) SearchRuns(request types.SearchRunsRequest) (*types.GetRunsResponse, error) {
+	endpoint := c.buildEndpoint("runs/search")
+
+	var runsResponse types.GetRunsResponse
+	if err := c.do(http.MethodPost, endpoint, c.readTimeout, request, &runsResponse); err != nil {
+		return nil, fmt.Errorf("failed to search runs: %w", err)
+	}
+
+	return &runsResponse, nil
+}
+
+func (c *httpMLflowClient) GetMetricHistory(runID string, metricKey string) (*types.GetMetricHistoryResponse, error) {
+	endpoint := c.buildEndpoint(fmt.Sprintf("metrics/get-history?run_id=%s&metric_key=%s",
+		url.QueryEscape(runID), url.QueryEscape(metricKey)))
+
+	var historyResponse types.GetMetricHistoryResponse
+	if err := c.do(http.MethodGet, endpoint, c.readTimeout, nil, &historyResponse); err != nil {
+		return nil, fmt.Errorf("failed to fetch metric history: %w", err)
+	}
+
+	return &historyResponse, nil
+}
+
+func (c *httpMLflowClient) GetExperimentByName(name string) (*types.GetExperimentResponse, error) {
+	endpoint := c.buildEndpoint("experiments/get-by-name?experiment_name=" + url.QueryEscape(name))
+
+	var experimentResponse types.GetExperimentResponse
+	if err := c.do(http.MethodGet, endpoint, c.readTimeout, nil, &experimentResponse); err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: %q", ErrExperimentNotFound, name)
+		}
+		return nil, fmt.Errorf("failed to fetch experiment: %w", err)
+	}
+
+	return &experimentResponse, nil
+}
+
+// Ping checks that the tracking server is reachable and accepts the
+// credentials by listing at most one experiment. experiments/list was
+// removed in MLflow 2.0, so experiments/search is used instead.
+func (c *httpMLflowClient) Ping() error {
+	endpoint := c.buildEndpoint("experiments/search?max_results=1")
+
+	if err := c.do(http.MethodGet, endpoint, c.readTimeout, nil, nil); err != nil {
+		return fmt.Errorf("failed to reach MLflow: %w", err)
+	}
+
+	return nil
+}
+
+func (c *httpMLflowClient) UpdateRun(runID string, status string) error {
+	endpoint := c.buildEndpoint("runs/update")
+
+	requestBody := map[string]string{
+		"run_id": runID,
+		"status": status,
+	}
+
+	if err := c.do(http.MethodPost, endpoint, c.mutationTimeout, requestBody, nil); err != nil {
+		return fmt.Errorf("failed to update run: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteRun soft-deletes runID, moving it to the trash of its experiment.
+func (c *httpMLflowClient) DeleteRun(runID string) error {
+	endpoint := c.buildEndpoint("runs/delete")
+
+	requestBody := map[string]string{
+		"run_id": runID,
+	}
+
+	if err := c.do(http.MethodPost, endpoint, c.mutationTimeout, requestBody, nil); err != nil {
+		return fmt.Errorf("failed to delete run: %w", err)
+	}
+
+	return nil
+}
+
+func (c *httpMLflowClient) SetTag(runID string, key string, value string) error {
+	endpoint := c.buildEndpoint("runs/set-tag")
+
+	requestBody := map[string]string{
+		"run_id": runID,
+		"key":    key,
+		"value":  value,
+	}
+
+	if err := c.do(http.MethodPost, endpoint, c.mutationTimeout, requestBody, nil); err != nil {
+		return fmt.Errorf("failed to set tag: %w", err)
+	}
+
+	return nil
+}
+
+// LogMetric logs a single metric value. timestamp is in milliseconds since
+// the epoch; MLflow rejects the request without it.
+func (c *httpMLflowClient) LogMetric(runID string, key string, value float64, timestamp int64, step int) error {
+	endpoint := c.buildEndpoint("runs/log-metric")
+
+	requestBody := map[string]interface{}{
+		"run_id":    runID,
+		"key":       key,
+		"value":     value,
+		"timestamp": timestamp,
+		"step":      step,
+	}
+
+	if err := c.do(http.MethodPost, endpoint, c.mutationTimeout, requestBody, nil); err != nil {
+		return fmt.Errorf("failed to log metric: %w", err)
+	}
+
+	return nil
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out when out is non-nil. The request is cancelled if it has
+// not completed within timeout. Connection failures and 5xx responses count
+// towards the circuit breaker; other statuses mean MLflow is up. The
+// request is built before the breaker is asked, so a request that cannot be
+// built never takes the half-open trial without recording its outcome.
+func (c *httpMLflowClient) do(method string, endpoint string, timeout time.Duration, body interface{}, out interface{}) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var reader io.Reader
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// A token takes precedence, since only one Authorization header can be
+	// sent; basic auth is for servers fronted by a proxy such as nginx.
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+
+	// Bodies and headers may carry tokens or secret run params, so they
+	// are redacted before being logged. Redaction is only paid for when
+	// debug logging is enabled.
+	if event := log.Debug(); event.Enabled() {
+		event = event.Str("method", method).Str("endpoint", redact(endpoint)).Str("headers", redactHeaders(req.Header))
+		if payload != nil {
+			event = event.Str("body", redact(string(payload)))
+		}
+		event.Msg("sending MLflow request")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	c.breaker.record(err != nil || (resp.StatusCode >= http.StatusInternalServerError))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMLflowUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if event := log.Debug(); event.Enabled() {
+		event.Str("method", method).Str("endpoint", redact(endpoint)).Str("status", resp.Status).Msg("MLflow response status")
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &apiError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	if event := log.Debug(); event.Enabled() {
+		event.Str("method", method).Str("endpoint", redact(endpoint)).Str("body", redact(string(respBody))).Msg("MLflow response body")
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package mlflow
+
+import (
+	"errors"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeoutsAreIndependent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		writeJSON(t, w, map[string]interface{}{})
+	}))
+	defer server.Close()
+
+	client := &httpMLflowClient{
+		baseURL:         server.URL,
+		httpClient:      server.Client(),
+		readTimeout:     time.Second,
+		mutationTimeout: 20 * time.Millisecond,
+	}
+
+	if _, err := client.SearchRuns(types.SearchRunsRequest{}); err != nil {
+		t.Errorf("SearchRuns() error = %v, want it to finish within the read timeout", err)
+	}
+	if err := client.UpdateRun("run-1", "FAILED"); err == nil {
+		t.Error("UpdateRun() expected to exceed the mutation timeout")
+	}
+}
+
+func TestBuildEndpointUsesAPIBasePath(t *testing.T) {
+	tests := []struct {
+		basePath string
+		want     string
+	}{
+		{"", "http://mlflow:5000/api/2.0/mlflow/runs/search"},
+		{"/api/2.0/mlflow", "http://mlflow:5000/api/2.0/mlflow/runs/search"},
+		{"mlflow-proxy/api/2.0/mlflow/", "http://mlflow:5000/mlflow-proxy/api/2.0/mlflow/runs/search"},
+	}
+
+	for _, tt := range tests {
+		client := &httpMLflowClient{baseURL: "http://mlflow:5000", apiBasePath: normalizeBasePath(tt.basePath)}
+		if got := client.buildEndpoint("runs/search"); got != tt.want {
+			t.Errorf("buildEndpoint() with base path %q = %q, want %q", tt.basePath, got, tt.want)
+		}
+	}
+}
+
+func TestClientClassifiesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"unauthorized", http.StatusUnauthorized, "", ErrMLflowUnauthorized},
+		{"forbidden", http.StatusForbidden, "", ErrMLflowUnauthorized},
+		{"server error", http.StatusServiceUnavailable, "", ErrMLflowUnavailable},
+		{"rate limited", http.StatusTooManyRequests, "", ErrMLflowUnavailable},
+		{"not found", http.StatusNotFound, "", ErrRunNotFound},
+		{"does not exist code", http.StatusBadRequest, `{"error_code": "RESOURCE_DOES_NOT_EXIST"}`, ErrRunNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := &httpMLflowClient{baseURL: server.URL, httpClient: server.Client()}
+			_, err := client.GetRun("run-1")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("GetRun() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientReportsUnreachableServerAsUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	client := &httpMLflowClient{baseURL: server.URL, httpClient: http.DefaultClient}
+	if _, err := client.SearchRuns(types.SearchRunsRequest{}); !errors.Is(err, ErrMLflowUnavailable) {
+		t.Errorf("SearchRuns() error = %v, want ErrMLflowUnavailable", err)
+	}
+}
+
+func TestClientSendsTrackingURIUserinfoAsBasicAuth(t *testing.T) {
+	var username, password, host string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ = r.BasicAuth()
+		host = r.Host
+		writeJSON(t, w, map[string]interface{}{})
+	}))
+	defer server.Close()
+
+	uri := strings.Replace(server.URL, "http://", "http://alice:s%40cret@", 1)
+	client := newTestClient(t, config.Config{MLflowTrackingURI: uri})
+
+	if _, err := client.SearchRuns(types.SearchRunsRequest{}); err != nil {
+		t.Fatalf("SearchRuns() error = %v", err)
+	}
+	if username != "alice" || password != "s@cret" {
+		t.Errorf("basic auth = %q:%q, want alice:s@cret", username, password)
+	}
+	if strings.Contains(client.(*httpMLflowClient).baseURL, "alice") || host != strings.TrimPrefix(server.URL, "http://") {
+		t.Errorf("baseURL = %q, want the credentials stripped", client.(*httpMLflowClient).baseURL)
+	}
+}
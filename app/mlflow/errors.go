@@ -0,0 +1,58 @@
+package mlflow
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors returned, wrapped, by MLflowClient so callers can tell failures
+// apart with errors.Is.
+var (
+	// ErrMLflowUnauthorized means MLflow rejected the credentials (401/403).
+	ErrMLflowUnauthorized = errors.New("MLflow rejected the credentials")
+	// ErrMLflowUnavailable means MLflow could not be reached or answered with
+	// a 5xx or 429 status; the request may succeed if retried later.
+	ErrMLflowUnavailable = errors.New("MLflow is unavailable")
+	// ErrRunNotFound means the requested run does not exist.
+	ErrRunNotFound = errors.New("run does not exist")
+	// ErrExperimentNotFound means the requested experiment does not exist.
+	ErrExperimentNotFound = errors.New("experiment does not exist")
+	// ErrRunAlreadyTerminal means the run finished on its own, or was
+	// deleted, before it could be stopped, so its status was left unchanged.
+	ErrRunAlreadyTerminal = errors.New("run is already terminal")
+)
+
+// apiError is returned by do when MLflow answers with a non-200 status. It
+// unwraps to the sentinel matching the status code, if any.
+type apiError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("MLflow API returned status code %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *apiError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrMLflowUnauthorized
+	case e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError:
+		return ErrMLflowUnavailable
+	}
+	return nil
+}
+
+// isNotFound reports whether err is MLflow saying the requested resource
+// does not exist. Depending on the version MLflow answers 404, or 400 with
+// the RESOURCE_DOES_NOT_EXIST error code.
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusNotFound ||
+		strings.Contains(apiErr.Body, "RESOURCE_DOES_NOT_EXIST")
+}
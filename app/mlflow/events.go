@@ -0,0 +1,89 @@
+package mlflow
+
+import (
+	"errors"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/events"
+	"github.com/gidra39/mlflow-autostop/types"
+	"sync"
+)
+
+// trackedRuns holds the IDs of the runs currently being monitored, so run
+// start and finish events are emitted once per run. The IDs are kept per
+// tracking server so that one server's poll does not report the runs of
+// another as finished.
+var trackedRuns sync.Map // tracking URI -> *sync.Map of run IDs
+
+// serverRuns returns the tracked runs of the server config points at.
+func serverRuns(config config.Config) *sync.Map {
+	runs, _ := trackedRuns.LoadOrStore(config.MLflowTrackingURI, &sync.Map{})
+	return runs.(*sync.Map)
+}
+
+// trackRun emits a run_started event the first time run is seen.
+func trackRun(run types.RunInfo, config config.Config) {
+	if _, seen := serverRuns(config).LoadOrStore(run.RunID, struct{}{}); !seen {
+		events.Emit(runEvent(events.TypeRunStarted, run), config)
+	}
+}
+
+// finishRun emits a run_finished event for a run that left the RUNNING state
+// on its own.
+func finishRun(run types.RunInfo, config config.Config) {
+	serverRuns(config).Delete(run.RunID)
+	events.Emit(runEvent(events.TypeRunFinished, run), config)
+}
+
+// syncTrackedRuns starts tracking the active runs of a poll and reports the
+// tracked runs that are no longer active as finished. Their final status is
+// unknown since search only returns active runs.
+func syncTrackedRuns(active []types.Run, config config.Config) {
+	current := make(map[string]bool, len(active))
+	for _, run := range active {
+		current[run.Info.RunID] = true
+		trackRun(run.Info, config)
+	}
+
+	serverRuns(config).Range(func(key, _ interface{}) bool {
+		runID := key.(string)
+		if !current[runID] {
+			finishRun(types.RunInfo{RunID: runID}, config)
+		}
+		return true
+	})
+}
+
+// emitViolation reports a warning or stop. A stopped run is no longer
+// tracked, so it does not also produce a run_finished event.
+func emitViolation(eventType string, run types.RunInfo, v violation, config config.Config) {
+	if eventType == events.TypeStopped {
+		serverRuns(config).Delete(run.RunID)
+	}
+
+	event := runEvent(eventType, run)
+	event.Metric = v.Metric
+	event.Value = finiteOrNil(v.Value)
+	event.Threshold = finiteOrNil(v.Threshold)
+	event.Reason = v.Reason
+	events.Emit(event, config)
+}
+
+// emitError reports a failed MLflow call, about runID when it concerns a
+// single run. Calls skipped by the open circuit breaker are not reported
+// again.
+func emitError(runID string, err error, config config.Config) {
+	if errors.Is(err, ErrCircuitOpen) {
+		return
+	}
+	events.Emit(events.Event{Type: events.TypeError, RunID: runID, Error: err.Error()}, config)
+}
+
+func runEvent(eventType string, run types.RunInfo) events.Event {
+	return events.Event{
+		Type:         eventType,
+		RunID:        run.RunID,
+		RunName:      run.RunName,
+		ExperimentID: run.ExperimentID,
+		Status:       run.Status,
+	}
+}
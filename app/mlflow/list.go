@@ -0,0 +1,84 @@
+package mlflow
+
+import (
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// ListRuns fetches the runs the given target would monitor, with their
+// latest metrics, without evaluating or stopping them.
+func ListRuns(client MLflowClient, runID string, experimentID string, runNamePattern string, config config.Config) ([]types.Run, error) {
+	if runID != "" {
+		run, err := client.GetRun(runID)
+		if err != nil {
+			return nil, err
+		}
+		return []types.Run{run.Run}, nil
+	}
+
+	runs := &types.GetRunsResponse{}
+	var err error
+	if runNamePattern != "" {
+		runs.Runs, err = getActiveRunsByName(client, runNamePattern, config)
+	} else if experimentID != "" {
+		runs, err = getActiveRunsInExperiment(client, experimentID, config)
+	} else {
+		runs, err = getAllActiveRuns(client, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, run := range runs.Runs {
+		if len(run.Data.Metrics) > 0 {
+			continue
+		}
+		full, err := client.GetRun(run.Info.RunID)
+		if err != nil {
+			return nil, err
+		}
+		runs.Runs[i] = full.Run
+	}
+	return runs.Runs, nil
+}
+
+// WriteRunTable prints one row per run metric with its threshold, marking
+// the metrics that would stop the run on the next poll.
+func WriteRunTable(w io.Writer, runs []types.Run, config config.Config) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tNAME\tSTATUS\tEXPERIMENT\tMETRIC\tVALUE\tTHRESHOLD\tTRIGGER")
+
+	for _, run := range runs {
+		info := run.Info
+		if len(run.Data.Metrics) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t-\t-\t-\t\n", info.RunID, info.RunName, info.Status, info.ExperimentID)
+			continue
+		}
+
+		metrics := append([]types.Metric(nil), run.Data.Metrics...)
+		sort.Slice(metrics, func(i, j int) bool { return metrics[i].Key < metrics[j].Key })
+
+		for _, metric := range metrics {
+			threshold := "-"
+			if t, ok := config.MetricThresholds.Lookup(metric.Key); ok {
+				threshold = t.String()
+			}
+
+			trigger := ""
+			if _, violated := metricViolation(run, metric, config); violated {
+				trigger = "STOP"
+			} else if _, warned := warningViolation(run, metric, config); warned {
+				trigger = "WARN"
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%.4f\t%s\t%s\n", info.RunID, info.RunName, info.Status,
+				info.ExperimentID, metric.Key, metric.Value, threshold, trigger)
+		}
+	}
+
+	return tw.Flush()
+}
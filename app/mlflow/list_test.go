@@ -0,0 +1,42 @@
+package mlflow
+
+import (
+	"bytes"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"strings"
+	"testing"
+)
+
+func TestWriteRunTableMarksTriggers(t *testing.T) {
+	cfg := config.Config{
+		MetricThresholds: config.Thresholds{"loss": config.MaxThreshold(5)},
+		WarnThresholds:   config.Thresholds{"accuracy": {Min: func() *float64 { v := 0.5; return &v }()}},
+	}
+	runs := []types.Run{
+		runningRun("run-1",
+			types.Metric{Key: "loss", Value: 6},
+			types.Metric{Key: "accuracy", Value: 0.4},
+		),
+		runningRun("run-2"),
+	}
+
+	var out bytes.Buffer
+	if err := WriteRunTable(&out, runs, cfg); err != nil {
+		t.Fatalf("WriteRunTable() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want header + 3 rows:\n%s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[1], "accuracy") || !strings.HasSuffix(lines[1], "WARN") {
+		t.Errorf("accuracy row = %q, want it marked WARN", lines[1])
+	}
+	if !strings.Contains(lines[2], "loss") || !strings.HasSuffix(lines[2], "STOP") {
+		t.Errorf("loss row = %q, want it marked STOP", lines[2])
+	}
+	if !strings.HasPrefix(lines[3], "run-2") {
+		t.Errorf("last row = %q, want the run without metrics", lines[3])
+	}
+}
@@ -0,0 +1,61 @@
+package mlflow
+
+import (
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"text/template"
+)
+
+// DefaultMessageTemplate renders the stop notification used when
+// Config.MessageTemplate is empty.
+const DefaultMessageTemplate = "🚫 Stopping run {{.RunID}}: {{.Reason}}"
+
+// stopMessageData is the data available to Config.MessageTemplate. Reason is
+// the full human-readable description of the violation; Threshold is NaN
+// when the violation is not a threshold crossing.
+type stopMessageData struct {
+	RunID     string
+	RunName   string
+	Metric    string
+	Value     float64
+	Threshold float64
+	Reason    string
+}
+
+// formatStopMessage renders the notification for v with the configured
+// template, falling back to the default if the template fails to execute.
+func formatStopMessage(v violation, config config.Config) string {
+	data := stopMessageData{
+		RunID:     v.RunID,
+		RunName:   v.RunName,
+		Metric:    v.Metric,
+		Value:     v.Value,
+		Threshold: v.Threshold,
+		Reason:    v.Reason,
+	}
+
+	if config.MessageTemplate != "" {
+		msg, err := renderTemplate(config.MessageTemplate, data)
+		if err == nil {
+			return msg
+		}
+		log.Error().Err(err).Msg("failed to render MESSAGE_TEMPLATE, using the default")
+	}
+
+	msg, _ := renderTemplate(DefaultMessageTemplate, data)
+	return msg
+}
+
+func renderTemplate(text string, data stopMessageData) (string, error) {
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,48 @@
+package mlflow
+
+import (
+	"github.com/gidra39/mlflow-autostop/config"
+	"math"
+	"testing"
+)
+
+func TestFormatStopMessage(t *testing.T) {
+	v := violation{
+		RunID:     "run-1",
+		RunName:   "sweep-7",
+		Metric:    "loss",
+		Value:     5.5,
+		Threshold: 5,
+		Reason:    "Metric loss = 5.5000 exceeded threshold 5.0000",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"default matches the legacy format", "",
+			"🚫 Stopping run run-1: Metric loss = 5.5000 exceeded threshold 5.0000"},
+		{"custom template", `{{.RunName}}: {{.Metric}}={{printf "%.1f" .Value}} (limit {{.Threshold}})`,
+			"sweep-7: loss=5.5 (limit 5)"},
+		{"broken template falls back", "{{.Missing}}",
+			"🚫 Stopping run run-1: Metric loss = 5.5000 exceeded threshold 5.0000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatStopMessage(v, config.Config{MessageTemplate: tt.template})
+			if got != tt.want {
+				t.Errorf("formatStopMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatStopMessageNaN(t *testing.T) {
+	v := violation{RunID: "run-1", Metric: "loss", Value: math.NaN(), Threshold: math.NaN(), Reason: "Metric loss went NaN"}
+
+	if got, want := formatStopMessage(v, config.Config{}), "🚫 Stopping run run-1: Metric loss went NaN"; got != want {
+		t.Errorf("formatStopMessage() = %q, want %q", got, want)
+	}
+}
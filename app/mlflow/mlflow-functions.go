@@ -1,384 +1,1018 @@
 package mlflow
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/events"
 	"github.com/gidra39/mlflow-autostop/messaging"
+	"github.com/gidra39/mlflow-autostop/sentry"
 	"github.com/gidra39/mlflow-autostop/types"
-	"io"
-	"log"
-	"net/http"
-	"strings"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"math"
+	"sort"
+	"sync"
 	"time"
 )
 
-func MonitorSpecificRun(runID string, config config.Config, debug bool) {
-	for {
-		run, err := getRunDetails(runID, config, debug)
-		if err != nil {
-			log.Printf("Error fetching run details: %v", err)
-			time.Sleep(time.Duration(config.PollInterval) * time.Second)
-			continue
-		}
+const (
+	// noteTag holds the run description shown in the MLflow UI.
+	noteTag = "mlflow.note.content"
+	// stopReasonTag holds the reason a run was stopped.
+	stopReasonTag = "autostop.reason"
+	// stopMetric is logged as 1 on a run just before it is stopped.
+	stopMetric = "autostop.triggered"
+	// parentRunTag holds the ID of the parent of a nested run.
+	parentRunTag = "mlflow.parentRunId"
+	// stoppingTag is set to "true" StopDelaySeconds before a run is stopped.
+	stoppingTag = "autostop.stopping"
+	// lifecycleDeleted is the lifecycle stage of a run in MLflow's trash.
+	lifecycleDeleted = "deleted"
+)
 
-		if run.Run.Info.Status != "RUNNING" {
-			log.Printf("Run %s is no longer active (status: %s), stopping monitoring",
-				runID, run.Run.Info.Status)
-			return
-		}
+// sleep is a variable so tests can skip the StopDelaySeconds wait.
+var sleep = time.Sleep
+
+// PollResult summarizes the outcome of one or more polls. Warned counts runs
+// with a metric past its warning threshold, whether or not a warning was
+// sent this poll.
+type PollResult struct {
+	Checked int
+	Warned  int
+	Stopped int
+	Errors  int
+}
 
-		for _, metric := range run.Run.Data.Metrics {
-			threshold, exists := config.MetricThresholds[metric.Key]
-			if exists && metric.Value > threshold {
-				msg := fmt.Sprintf("🚫 Stopping run %s: Metric %s = %.4f exceeded threshold %.4f",
-					runID, metric.Key, metric.Value, threshold)
-				log.Println(msg)
+// Add accumulates other into r.
+func (r *PollResult) Add(other PollResult) {
+	r.Checked += other.Checked
+	r.Warned += other.Warned
+	r.Stopped += other.Stopped
+	r.Errors += other.Errors
+}
 
-				err := messaging.SendNotification(msg, config)
-				if err != nil {
-					log.Printf("Failed to send notification: %v", err)
-				}
+func (r PollResult) String() string {
+	return fmt.Sprintf("%d checked, %d warned, %d stopped, %d errors", r.Checked, r.Warned, r.Stopped, r.Errors)
+}
 
-				if err := stopRun(runID, config, debug); err != nil {
-					log.Printf("Failed to stop run: %v", err)
-				}
+// MonitorSpecificRun polls runID until it leaves the RUNNING state, is
+// stopped or ctx is cancelled, returning the accumulated result.
+func MonitorSpecificRun(ctx context.Context, client MLflowClient, runID string, config config.Config) PollResult {
+	ticker := time.NewTicker(time.Duration(config.PollInterval) * time.Second)
+	defer ticker.Stop()
+	wake, unsubscribe := subscribePollNow()
+	defer unsubscribe()
 
-				return
-			}
+	var total PollResult
+	for {
+		result, active := PollSpecificRun(client, runID, config)
+		total.Add(result)
+		if !active || !waitForTick(ctx, ticker, wake) {
+			return total
 		}
+	}
+}
 
-		log.Printf("Run %s metrics are within acceptable thresholds", runID)
-		time.Sleep(time.Duration(config.PollInterval) * time.Second)
+// MonitorExperiment polls the active runs in experimentID until ctx is
+// cancelled.
+func MonitorExperiment(ctx context.Context, client MLflowClient, experimentID string, config config.Config) {
+	ticker := time.NewTicker(experimentPollInterval(experimentID, config))
+	defer ticker.Stop()
+	wake, unsubscribe := subscribePollNow()
+	defer unsubscribe()
+
+	for {
+		PollExperiment(client, experimentID, config)
+		if !waitForTick(ctx, ticker, wake) {
+			return
+		}
 	}
 }
 
-func MonitorExperiment(experimentID string, config config.Config, debug bool) {
+// MonitorAllActiveRuns polls every active run on the server until ctx is
+// cancelled, backing off while there are none.
+func MonitorAllActiveRuns(ctx context.Context, client MLflowClient, config config.Config) {
+	baseInterval := time.Duration(config.PollInterval) * time.Second
+	idleInterval := baseInterval
+	interval := baseInterval
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	wake, unsubscribe := subscribePollNow()
+	defer unsubscribe()
+
 	for {
-		activeRuns, err := getActiveRunsInExperiment(experimentID, config, debug)
-		if err != nil {
-			log.Printf("Error fetching active runs: %v", err)
-			time.Sleep(time.Duration(config.PollInterval) * time.Second)
-			continue
+		result := PollAllActiveRuns(client, config)
+
+		next := baseInterval
+		if result.Errors == 0 && result.Checked == 0 {
+			next = idleInterval
+			idleInterval = nextIdleInterval(idleInterval, config)
+		} else {
+			idleInterval = baseInterval
 		}
 
-		if len(activeRuns.Runs) == 0 {
-			log.Printf("No active runs found in experiment %s", experimentID)
-			time.Sleep(time.Duration(config.PollInterval) * time.Second)
-			continue
+		if next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+		if !waitForTick(ctx, ticker, wake) {
+			return
 		}
+	}
+}
+
+// pollNowSubscribers holds a wake channel per running monitor loop, so that
+// PollNow reaches every loop when several tracking servers are monitored.
+var (
+	pollNowMu          sync.Mutex
+	pollNowSubscribers = map[chan struct{}]struct{}{}
+)
+
+// PollNow makes the running monitor loops poll immediately instead of
+// waiting for the rest of the current interval.
+func PollNow() {
+	pollNowMu.Lock()
+	defer pollNowMu.Unlock()
 
-		for _, run := range activeRuns.Runs {
-			checkRunMetrics(run.Info.RunID, config, debug)
+	for wake := range pollNowSubscribers {
+		select {
+		case wake <- struct{}{}:
+		default:
 		}
+	}
+}
+
+// subscribePollNow returns the channel a monitor loop waits on for PollNow
+// requests and the function removing it. The channel holds at most one
+// pending request; more requests before the poll starts are merged.
+func subscribePollNow() (<-chan struct{}, func()) {
+	wake := make(chan struct{}, 1)
+
+	pollNowMu.Lock()
+	defer pollNowMu.Unlock()
+	pollNowSubscribers[wake] = struct{}{}
 
-		time.Sleep(time.Duration(config.PollInterval) * time.Second)
+	return wake, func() {
+		pollNowMu.Lock()
+		defer pollNowMu.Unlock()
+		delete(pollNowSubscribers, wake)
 	}
 }
 
-func MonitorAllActiveRuns(config config.Config, debug bool) {
-	for {
-		activeRuns, err := getAllActiveRuns(config, debug)
-		if err != nil {
-			log.Printf("Error fetching active runs: %v", err)
-			time.Sleep(time.Duration(config.PollInterval) * time.Second)
-			continue
-		}
+// experimentPollInterval returns the ExperimentPollIntervals override for
+// experimentID, falling back to the global PollInterval.
+func experimentPollInterval(experimentID string, config config.Config) time.Duration {
+	if seconds, ok := config.ExperimentPollIntervals[experimentID]; ok && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return time.Duration(config.PollInterval) * time.Second
+}
+
+// waitForTick blocks until the next tick so polls start on a fixed cadence
+// regardless of how long each one takes, or until a PollNow request arrives on
+// wake. A tick
+// that fell due while the previous poll was still running is dropped instead
+// of triggering a poll straight away. It returns false once ctx is cancelled.
+func waitForTick(ctx context.Context, ticker *time.Ticker, wake <-chan struct{}) bool {
+	select {
+	case <-ticker.C:
+		log.Debug().Msg("previous poll overran the poll interval, skipping a tick")
+	default:
+	}
+
+	select {
+	case <-ticker.C:
+	case <-wake:
+		log.Info().Msg("immediate poll requested")
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
+
+// PollSpecificRun checks runID once. active is false when the run has left
+// the RUNNING state, was stopped by this check or does not exist.
+func PollSpecificRun(client MLflowClient, runID string, config config.Config) (result PollResult, active bool) {
+	defer saveState()
+
+	run, err := client.GetRun(runID)
+	if err != nil {
+		errorEvent(err).Str("run_id", runID).Msg("error fetching run details")
+		emitError(runID, err, config)
+		result.Errors++
+		return result, !errors.Is(err, ErrRunNotFound)
+	}
+
+	if run.Run.Info.Status != "RUNNING" {
+		log.Info().Str("run_id", runID).Str("status", run.Run.Info.Status).
+			Msg("run is no longer active, stopping monitoring")
+		finishRun(run.Run.Info, config)
+		return result, false
+	}
+
+	trackRun(run.Run.Info, config)
+
+	result.Add(evaluateRun(client, run.Run, config))
+	return result, result.Stopped == 0
+}
+
+// PollExperiment checks every active run in experimentID once.
+func PollExperiment(client MLflowClient, experimentID string, config config.Config) PollResult {
+	defer saveState()
+
+	activeRuns, err := getActiveRunsInExperiment(client, experimentID, config)
+	if err != nil {
+		errorEvent(err).Msg("error fetching active runs")
+		emitError("", err, config)
+		return PollResult{Errors: 1}
+	}
+	syncTrackedRuns(activeRuns.Runs, config)
+
+	if len(activeRuns.Runs) == 0 {
+		log.Info().Str("experiment_id", experimentID).Msg("no active runs found in experiment")
+		return PollResult{}
+	}
+
+	result := checkRuns(client, activeRuns.Runs, config)
+	reportPoll(result, config)
+	return result
+}
+
+// PollAllActiveRuns checks every active run on the server once.
+func PollAllActiveRuns(client MLflowClient, config config.Config) PollResult {
+	defer saveState()
+
+	activeRuns, err := getAllActiveRuns(client, config)
+	if err != nil {
+		errorEvent(err).Msg("error fetching active runs")
+		emitError("", err, config)
+		return PollResult{Errors: 1}
+	}
+	syncTrackedRuns(activeRuns.Runs, config)
+
+	if len(activeRuns.Runs) == 0 {
+		log.Info().Msg("no active runs found")
 
-		if len(activeRuns.Runs) == 0 {
-			log.Println("No active runs found")
-
-			if debug {
-				allRuns, err := getAllRuns(config, debug)
-				if err != nil {
-					log.Printf("Debug: Error fetching all runs: %v", err)
-				} else {
-					log.Printf("Debug: Found %d total runs (any status)", len(allRuns.Runs))
-					for i, run := range allRuns.Runs {
-						if i < 5 { // Only show first 5 to avoid log flooding
-							log.Printf("Debug: Run ID: %s, Status: %s",
-								run.Info.RunID, run.Info.Status)
-						}
+		if event := log.Debug(); event.Enabled() {
+			allRuns, err := getAllRuns(client)
+			if err != nil {
+				log.Debug().Err(err).Msg("error fetching all runs")
+			} else {
+				log.Debug().Int("count", len(allRuns.Runs)).Msg("found runs (any status)")
+				for i, run := range allRuns.Runs {
+					if i < 5 { // Only show first 5 to avoid log flooding
+						log.Debug().Str("run_id", run.Info.RunID).Str("status", run.Info.Status).Msg("run")
 					}
 				}
 			}
-
-			time.Sleep(time.Duration(config.PollInterval) * time.Second)
-			continue
 		}
 
-		for _, run := range activeRuns.Runs {
-			checkRunMetrics(run.Info.RunID, config, debug)
-		}
+		return PollResult{}
+	}
+
+	result := checkRuns(client, activeRuns.Runs, config)
+	reportPoll(result, config)
+	return result
+}
 
-		time.Sleep(time.Duration(config.PollInterval) * time.Second)
+// errorEvent starts an error log entry for err, demoted to debug while the
+// circuit breaker is open since the outage was already reported once.
+func errorEvent(err error) *zerolog.Event {
+	if errors.Is(err, ErrCircuitOpen) {
+		return log.Debug().Err(err)
 	}
+	return log.Error().Err(err)
 }
 
-func checkRunMetrics(runID string, config config.Config, debug bool) {
-	run, err := getRunDetails(runID, config, debug)
-	if err != nil {
-		log.Printf("Error fetching details for run %s: %v", runID, err)
+// withinThresholdEvent returns the log event for a run found within its
+// thresholds, demoted to debug by QuietWithinThreshold.
+func withinThresholdEvent(config config.Config) *zerolog.Event {
+	if config.QuietWithinThreshold {
+		return log.Debug()
+	}
+	return log.Info()
+}
+
+// reportPoll logs the summary of a poll over several runs and, with
+// NotifyPollSummary, sends it when the poll stopped runs or hit errors.
+func reportPoll(result PollResult, config config.Config) {
+	log.Info().Int("checked", result.Checked).Int("warned", result.Warned).
+		Int("stopped", result.Stopped).Int("errors", result.Errors).Msg("poll complete")
+
+	if !config.NotifyPollSummary || (result.Stopped == 0 && result.Errors == 0) {
 		return
 	}
+	if err := messaging.SendNotification("📊 Poll complete: "+result.String(), types.SeverityInfo, config); err != nil {
+		log.Error().Err(err).Msg("failed to send notification")
+	}
+}
+
+// scopeRuns keeps the runs that RunScope selects: with children only nested
+// runs, with parents only top-level runs.
+func scopeRuns(runs []types.Run, config config.Config) []types.Run {
+	if config.RunScope == "" || config.RunScope == "all" {
+		return runs
+	}
+
+	wantChildren := config.RunScope == "children"
+	var scoped []types.Run
+	for _, run := range runs {
+		parentID, _ := types.LookupTag(run.Data.Tags, parentRunTag)
+		if (parentID != "") == wantChildren {
+			scoped = append(scoped, run)
+		}
+	}
 
-	for _, metric := range run.Run.Data.Metrics {
-		threshold, exists := config.MetricThresholds[metric.Key]
-		if exists && metric.Value > threshold {
-			msg := fmt.Sprintf("🚫 Stopping run %s: Metric %s = %.4f exceeded threshold %.4f",
-				runID, metric.Key, metric.Value, threshold)
-			log.Println(msg)
+	log.Debug().Str("scope", config.RunScope).Int("runs", len(runs)).Int("in_scope", len(scoped)).Msg("applied run scope")
+	return scoped
+}
 
-			err := messaging.SendNotification(msg, config)
-			if err != nil {
-				log.Printf("Failed to send notification: %v", err)
+// checkRuns evaluates runs returned by runs/search. The search response
+// already embeds each run's latest metrics, so runs/get is only called for
+// runs that came back without any.
+func checkRuns(client MLflowClient, runs []types.Run, config config.Config) PollResult {
+	latest := ""
+	if config.PreserveLatest {
+		latest = latestRunID(runs)
+	}
+
+	var result PollResult
+	held := 0
+	for _, run := range runs {
+		if run.Info.RunID == latest {
+			result.Checked++
+			if v, violated := violatedRules(client, run, config); violated {
+				log.Info().Str("run_id", latest).Str("reason", v.Reason).
+					Msg("not stopping the most recently started run")
 			}
+			continue
+		}
 
-			if err := stopRun(runID, config, debug); err != nil {
-				log.Printf("Failed to stop run: %v", err)
+		if config.MaxStopsPerPoll > 0 && result.Stopped >= config.MaxStopsPerPoll {
+			result.Checked++
+			if v, violated := violatedRules(client, run, config); violated {
+				held++
+				log.Warn().Str("run_id", run.Info.RunID).Str("reason", v.Reason).
+					Msg("stop limit for this poll reached, not stopping run")
 			}
+			continue
+		}
 
-			return
+		if len(run.Data.Metrics) == 0 {
+			result.Add(checkRunMetrics(client, run.Info.RunID, config))
+			continue
+		}
+
+		result.Add(evaluateRun(client, run, config))
+	}
+
+	if held > 0 {
+		msg := fmt.Sprintf("⚠️ MAX_STOPS_PER_POLL (%d) reached: %d more runs violate their rules but were not stopped. "+
+			"Check the thresholds; the runs are re-evaluated next poll.", config.MaxStopsPerPoll, held)
+		log.Error().Int("held", held).Msg(msg)
+		if err := messaging.SendNotification(msg, types.SeverityWarning, config); err != nil {
+			log.Error().Err(err).Msg("failed to send notification")
 		}
 	}
+	return result
+}
+
+// violatedRules evaluates run without acting on the result.
+func violatedRules(client MLflowClient, run types.Run, config config.Config) (violation, bool) {
+	_, v, violated := evaluateRules(client, run, config, inGracePeriod(run, config))
+	return v, violated
+}
 
-	log.Printf("Run %s metrics are within acceptable thresholds", runID)
+// latestRunID returns the ID of the run with the latest start time.
+func latestRunID(runs []types.Run) string {
+	latest := ""
+	var latestStart int64
+	for _, run := range runs {
+		if latest == "" || run.Info.StartTime > latestStart {
+			latest = run.Info.RunID
+			latestStart = run.Info.StartTime
+		}
+	}
+	return latest
 }
 
-func getRunDetails(runID string, config config.Config, debug bool) (*types.GetRunResponse, error) {
-	endpoint := fmt.Sprintf("%s/api/2.0/mlflow/runs/get?run_id=%s", config.MLflowTrackingURI, runID)
+// nextIdleInterval doubles the sleep after a poll that found no active runs,
+// capped at MaxIdleIntervalSeconds. Without a cap configured the base poll
+// interval is kept.
+func nextIdleInterval(current time.Duration, config config.Config) time.Duration {
+	maxInterval := time.Duration(config.MaxIdleIntervalSeconds) * time.Second
+	if maxInterval <= 0 {
+		return current
+	}
 
-	if debug {
-		log.Printf("Debug: Fetching run details from: %s", endpoint)
+	next := current * 2
+	if next > maxInterval {
+		next = maxInterval
+	}
+	if next < current {
+		return current
 	}
+	return next
+}
 
-	resp, err := http.Get(endpoint)
+// checkRunMetrics fetches runID and stops it if any metric violates its
+// threshold.
+func checkRunMetrics(client MLflowClient, runID string, config config.Config) PollResult {
+	run, err := client.GetRun(runID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch run details: %v", err)
+		errorEvent(err).Str("run_id", runID).Msg("error fetching run details")
+		emitError(runID, err, config)
+		return PollResult{Errors: 1}
 	}
-	defer resp.Body.Close()
 
-	if debug {
-		log.Printf("Debug: Run details API response status: %s", resp.Status)
+	return evaluateRun(client, run.Run, config)
+}
+
+// stopping holds the IDs of runs whose stop is in progress so that
+// concurrent monitor loops in this process don't stop the same run twice.
+var stopping sync.Map
+
+// evaluateRun stops run on the first metric violating its threshold, sending
+// a notification first, and warns about metrics past their warning
+// threshold otherwise.
+func evaluateRun(client MLflowClient, run types.Run, config config.Config) PollResult {
+	result := PollResult{Checked: 1}
+	runID := run.Info.RunID
+	if recentlyStopped(runID, config) {
+		log.Debug().Str("run_id", runID).Msg("run was stopped recently and MLflow still reports it running, skipping")
+		return result
 	}
+	if len(run.Data.Metrics) == 0 && len(config.RequiredMetrics) == 0 {
+		log.Debug().Str("run_id", runID).Msg("run has no metrics yet, skipping")
+		return result
+	}
+	logWatchedMetrics(run, config)
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("MLflow API returned status code %d: %s",
-			resp.StatusCode, string(bodyBytes))
+	inGrace := inGracePeriod(run, config)
+	if inGrace {
+		log.Debug().Str("run_id", runID).Msg("run is within its grace period, only checking for NaN/Inf")
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+	metrics, v, violated := evaluateRules(client, run, config, inGrace)
+	if violated && config.ConfirmBeforeStop {
+		fresh, err := client.GetRun(runID)
+		if err != nil {
+			errorEvent(err).Str("run_id", runID).Msg("failed to re-fetch run to confirm violation, retrying next poll")
+			result.Errors++
+			return result
+		}
+		run = fresh.Run
+		if _, v, violated = evaluateRules(client, run, config, inGrace); !violated {
+			log.Info().Str("run_id", runID).Msg("violation did not persist in the re-fetched run, not stopping it")
+		}
+	}
+	if violated {
+		if _, busy := stopping.LoadOrStore(runID, struct{}{}); busy {
+			log.Debug().Str("run_id", runID).Msg("run is already being stopped, skipping")
+			return result
+		}
+		defer stopping.Delete(runID)
+
+		if config.SoftStopTag != "" {
+			requested := stopRequestedAt(runID)
+			if requested.IsZero() {
+				return requestSoftStop(client, run, v, config)
+			}
+			if remaining := time.Duration(config.SoftStopGraceSeconds)*time.Second - time.Since(requested); remaining > 0 {
+				log.Info().Str("run_id", runID).Dur("remaining", remaining).
+					Msg("waiting for run to finish after soft stop request")
+				return result
+			}
+			log.Warn().Str("run_id", runID).Msg("run did not finish within the soft stop grace period, stopping it")
+		}
+
+		recordViolation(runID, v.Metric)
+		msg := formatStopMessage(v, config)
+		log.Warn().Str("run_id", runID).Msg(msg)
+
+		err := messaging.SendNotification(msg, v.severity(), config.ForExperiment(run.Info.ExperimentID), v.fields()...)
+		if err != nil {
+			log.Error().Err(err).Str("run_id", runID).Msg("failed to send notification")
+			if config.RequireNotification {
+				log.Warn().Str("run_id", runID).
+					Msg("not stopping run until the notification is delivered, retrying next poll")
+				result.Errors++
+				return result
+			}
+		}
+
+		if config.LogStopMetric {
+			if err := logRunMetric(client, runID, stopMetric, 1, v.Step); err != nil {
+				log.Error().Err(err).Str("run_id", runID).Msg("failed to log stop metric")
+			}
+		}
+
+		if config.StopDelaySeconds > 0 {
+			delayStop(client, runID, config)
+		}
+
+		err = stopRun(client, runID, config.StopAction)
+		if errors.Is(err, ErrRunAlreadyTerminal) {
+			log.Info().Err(err).Str("run_id", runID).Msg("run finished before it could be stopped, leaving its status unchanged")
+			finishRun(run.Info, config)
+			recordStop(run.Info, v, err)
+			return result
+		}
+		recordStop(run.Info, v, err)
+		if err != nil {
+			errorEvent(err).Str("run_id", runID).Msg("failed to stop run")
+			emitError(runID, err, config)
+			if !errors.Is(err, ErrCircuitOpen) {
+				sentry.CaptureException(fmt.Errorf("failed to stop run %s: %w", runID, err))
+			}
+			result.Errors++
+			return result
+		}
+
+		markStopped(runID)
+		emitViolation(events.TypeStopped, run.Info, v, config)
+		if config.WriteStopNote {
+			writeStopNote(client, runID, v, config.StopAction)
+		}
+		result.Stopped++
+		return result
 	}
 
-	if debug {
-		log.Printf("Debug: Run details API response body: %s", string(body))
+	warned := false
+	if !inGrace {
+		for _, metric := range metrics {
+			if checkWarning(run, metric, config) {
+				warned = true
+			}
+		}
+	}
+	if !config.StopOnMissingMetric && checkMissingMetric(run, config) {
+		warned = true
+	}
+	if config.NotifyStalledRuns && checkStalledRun(run, config) {
+		warned = true
+	}
+	if warned {
+		result.Warned++
+	}
+
+	withinThresholdEvent(config).Str("run_id", runID).Msg("run metrics are within acceptable thresholds")
+	return result
+}
+
+// logWatchedMetrics logs the latest value of every WatchMetrics entry the run
+// has logged, whether or not it has a threshold.
+func logWatchedMetrics(run types.Run, config config.Config) {
+	for _, key := range config.WatchMetrics {
+		for _, metric := range run.Data.Metrics {
+			if metric.Key == key {
+				log.Info().Str("run_id", run.Info.RunID).Str("metric", metric.Key).
+					Float64("value", metric.Value).Int("step", metric.Step).Msg("watched metric")
+				break
+			}
+		}
 	}
+}
 
-	var runResponse types.GetRunResponse
-	if err := json.Unmarshal(body, &runResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %v", err)
+// requestSoftStop sets SoftStopTag on run so a cooperative training script
+// can checkpoint and finish on its own. evaluateRun stops the run if it is
+// still violating its rules once SoftStopGraceSeconds have passed.
+func requestSoftStop(client MLflowClient, run types.Run, v violation, config config.Config) PollResult {
+	result := PollResult{Checked: 1}
+	runID := run.Info.RunID
+
+	recordViolation(runID, v.Metric)
+	if err := setRunTag(client, runID, config.SoftStopTag, "true"); err != nil {
+		log.Error().Err(err).Str("run_id", runID).Msg("failed to request soft stop")
+		result.Errors++
+		return result
 	}
+	requestStop(runID)
 
-	return &runResponse, nil
+	msg := fmt.Sprintf("⏳ Requested run %s to stop: %s", runID, v.Reason)
+	log.Warn().Str("run_id", runID).Msg(msg)
+	if err := messaging.SendNotification(msg, v.severity(), config.ForExperiment(run.Info.ExperimentID), v.fields()...); err != nil {
+		log.Error().Err(err).Str("run_id", runID).Msg("failed to send notification")
+	}
+	return result
 }
 
-func getActiveRunsInExperiment(experimentID string, config config.Config, debug bool) (*types.GetRunsResponse, error) {
-	endpoint := fmt.Sprintf("%s/api/2.0/mlflow/runs/search", config.MLflowTrackingURI)
+// evaluateRules checks the metrics of run against the configured rules and
+// returns the first violation that should stop it. metrics holds the values
+// the rules were evaluated on, smoothed where SmoothingWindow asks for it.
+// ComputedMetrics are evaluated over these values and appended to metrics;
+// CompositeScores are computed from them too.
+// Metrics logged under a MetricAliases alias are evaluated under their
+// canonical key.
+// During the grace period only step limits and NaN/Inf values are considered.
+func evaluateRules(client MLflowClient, run types.Run, config config.Config, inGrace bool) (metrics []types.Metric, v violation, violated bool) {
+	run, loggedKeys := resolveAliases(run, config)
+	if len(loggedKeys) > 0 {
+		client = aliasedClient{MLflowClient: client, loggedKeys: loggedKeys}
+	}
+	metrics = make([]types.Metric, 0, len(run.Data.Metrics))
+	reference := baselineMetrics(client, config)
+
+	for _, metric := range run.Data.Metrics {
+		if age, stale := metricAge(metric, config); stale {
+			log.Debug().Str("run_id", run.Info.RunID).Str("metric", metric.Key).Dur("age", age).
+				Msg("metric is stale, skipping")
+			continue
+		}
+		if v, violated := stepViolation(run.Info, metric, config); violated {
+			return metrics, v, true
+		}
+
+		finite := !math.IsNaN(metric.Value) && !math.IsInf(metric.Value, 0)
+		if inGrace && finite {
+			continue
+		}
+
+		if finite {
+			metric = smoothMetric(client, run.Info.RunID, metric, config)
+		}
+		metrics = append(metrics, metric)
 
-	if debug {
-		log.Printf("Debug: Searching for active runs in experiment %s at: %s",
-			experimentID, endpoint)
+		if v, violated := metricViolation(run, metric, config); patienceExhausted(run, v, violated, config) {
+			return metrics, v, true
+		}
+		if !finite {
+			continue
+		}
+		if v, violated := baselineViolation(run.Info, metric, reference, config); violated {
+			return metrics, v, true
+		}
+		if v, violated := regressionViolation(client, run.Info, metric, config); violated {
+			return metrics, v, true
+		}
 	}
 
-	requestBody := fmt.Sprintf(`{
-		"experiment_ids": ["%s"],
-		"filter": "attributes.status = 'RUNNING'"
-	}`, experimentID)
+	if !inGrace {
+		for _, metric := range computedMetrics(run.Info.RunID, metrics, config) {
+			metrics = append(metrics, metric)
+			if v, violated := metricViolation(run, metric, config); violated {
+				return metrics, v, true
+			}
+		}
+		if v, violated := compositeViolation(run.Info, metrics, config); violated {
+			return metrics, v, true
+		}
+	}
 
-	resp, err := http.Post(endpoint, "application/json", strings.NewReader(requestBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch active runs: %v", err)
+	if config.StopOnMissingMetric {
+		if v, violated := missingMetricViolation(run, config); violated {
+			return metrics, v, true
+		}
 	}
-	defer resp.Body.Close()
 
-	if debug {
-		log.Printf("Debug: Active runs API response status: %s", resp.Status)
+	return metrics, violation{}, false
+}
+
+// Aggregations applied by smoothMetric over the SmoothingWindow points.
+const (
+	aggregationLast = "last"
+	aggregationMax  = "max"
+	aggregationMin  = "min"
+	aggregationMean = "mean"
+)
+
+// metricAggregation returns the MetricAggregation entry for metric. Without
+// one a smoothing window keeps averaging, as it did before aggregations
+// could be chosen.
+func metricAggregation(metric string, config config.Config) string {
+	if aggregation := config.MetricAggregation[metric]; aggregation != "" {
+		return aggregation
 	}
+	return aggregationMean
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("MLflow API returned status code %d: %s",
-			resp.StatusCode, string(bodyBytes))
+// smoothMetric replaces the value of metric with the aggregate (mean, max or
+// min) of its last SmoothingWindow history points. The latest value is kept
+// when no window is configured, the aggregation is last or the history
+// cannot be fetched.
+func smoothMetric(client MLflowClient, runID string, metric types.Metric, config config.Config) types.Metric {
+	window := config.SmoothingWindow[metric.Key]
+	aggregation := metricAggregation(metric.Key, config)
+	if window <= 1 || aggregation == aggregationLast {
+		return metric
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	history, err := client.GetMetricHistory(runID, metric.Key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		log.Error().Err(err).Str("run_id", runID).Str("metric", metric.Key).
+			Msg("failed to fetch metric history, using latest value")
+		return metric
+	}
+
+	points := history.Metrics
+	if len(points) == 0 {
+		return metric
 	}
 
-	if debug {
-		log.Printf("Debug: Active runs API response body: %s", string(body))
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].Step != points[j].Step {
+			return points[i].Step < points[j].Step
+		}
+		return points[i].Timestamp < points[j].Timestamp
+	})
+	if len(points) > window {
+		points = points[len(points)-window:]
 	}
 
-	var runsResponse types.GetRunsResponse
-	if err := json.Unmarshal(body, &runsResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %v", err)
+	var values []float64
+	for _, point := range points {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+		values = append(values, point.Value)
+	}
+	if len(values) == 0 {
+		return metric
 	}
+	metric.Value = aggregate(aggregation, values)
 
-	return &runsResponse, nil
+	log.Debug().Str("run_id", runID).Str("metric", metric.Key).Str("aggregation", aggregation).
+		Float64("value", metric.Value).Int("points", len(values)).Msg("smoothed metric value")
+	return metric
+}
+
+// aggregate reduces the non-empty values with aggregation.
+func aggregate(aggregation string, values []float64) float64 {
+	result := values[0]
+	switch aggregation {
+	case aggregationMax:
+		for _, value := range values[1:] {
+			result = math.Max(result, value)
+		}
+	case aggregationMin:
+		for _, value := range values[1:] {
+			result = math.Min(result, value)
+		}
+	default:
+		for _, value := range values[1:] {
+			result += value
+		}
+		result /= float64(len(values))
+	}
+	return result
 }
 
-func getAllRuns(config config.Config, debug bool) (*types.GetRunsResponse, error) {
-	endpoint := fmt.Sprintf("%s/api/2.0/mlflow/runs/search", config.MLflowTrackingURI)
+// checkWarning notifies, without stopping, the first time metric crosses its
+// warning threshold. It reports whether metric is past the threshold.
+func checkWarning(run types.Run, metric types.Metric, config config.Config) bool {
+	v, violated := warningViolation(run, metric, config)
+	if !violated {
+		clearWarning(run.Info.RunID, metric.Key)
+		return false
+	}
+	if !markWarning(run.Info.RunID, metric.Key) {
+		return true
+	}
+
+	emitViolation(events.TypeWarning, run.Info, v, config)
+
+	msg := fmt.Sprintf("⚠️ Warning for run %s: %s", run.Info.RunID, v.Reason)
+	log.Warn().Str("run_id", run.Info.RunID).Msg(msg)
 
-	if debug {
-		log.Printf("Debug: Searching for all runs at: %s", endpoint)
+	if err := messaging.SendNotification(msg, v.severity(), config.ForExperiment(run.Info.ExperimentID), v.fields()...); err != nil {
+		log.Error().Err(err).Str("run_id", run.Info.RunID).Msg("failed to send notification")
 	}
+	return true
+}
 
-	requestBody := `{"max_results": 100}`
+// stalledAlertKey keys the alert state of a stalled run. It cannot clash with
+// a metric key, which MLflow does not allow to contain a colon.
+const stalledAlertKey = "stalled:"
 
-	resp, err := http.Post(endpoint, "application/json", strings.NewReader(requestBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch all runs: %v", err)
+// checkStalledRun notifies, without stopping, the first time every metric of
+// run has gone stale. It reports whether the run is stalled.
+func checkStalledRun(run types.Run, config config.Config) bool {
+	v, stalled := stalledViolation(run, config)
+	if !stalled {
+		clearWarning(run.Info.RunID, stalledAlertKey)
+		return false
 	}
-	defer resp.Body.Close()
+	if !markWarning(run.Info.RunID, stalledAlertKey) {
+		return true
+	}
+
+	emitViolation(events.TypeWarning, run.Info, v, config)
 
-	if debug {
-		log.Printf("Debug: All runs API response status: %s", resp.Status)
+	msg := fmt.Sprintf("⚠️ Warning for run %s: %s", run.Info.RunID, v.Reason)
+	log.Warn().Str("run_id", run.Info.RunID).Msg(msg)
+
+	if err := messaging.SendNotification(msg, v.severity(), config.ForExperiment(run.Info.ExperimentID), v.fields()...); err != nil {
+		log.Error().Err(err).Str("run_id", run.Info.RunID).Msg("failed to send notification")
 	}
+	return true
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("MLflow API returned status code %d: %s",
-			resp.StatusCode, string(bodyBytes))
+// missingAlertPrefix keys the alert state of a missing required metric apart
+// from the warning threshold alerts of the same metric.
+const missingAlertPrefix = "missing:"
+
+// checkMissingMetric notifies, without stopping, the first time a required
+// metric goes missing or stale. It reports whether one is.
+func checkMissingMetric(run types.Run, config config.Config) bool {
+	v, missing := missingMetricViolation(run, config)
+	if !missing {
+		for _, key := range config.RequiredMetrics {
+			clearWarning(run.Info.RunID, missingAlertPrefix+key)
+		}
+		return false
+	}
+	if !markWarning(run.Info.RunID, missingAlertPrefix+v.Metric) {
+		return true
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+	emitViolation(events.TypeWarning, run.Info, v, config)
+
+	msg := fmt.Sprintf("⚠️ Warning for run %s: %s", run.Info.RunID, v.Reason)
+	log.Warn().Str("run_id", run.Info.RunID).Msg(msg)
+
+	if err := messaging.SendNotification(msg, v.severity(), config.ForExperiment(run.Info.ExperimentID), v.fields()...); err != nil {
+		log.Error().Err(err).Str("run_id", run.Info.RunID).Msg("failed to send notification")
 	}
+	return true
+}
 
-	if debug {
-		log.Printf("Debug: All runs API response body: %s", string(body))
+// inGracePeriod reports whether run started less than GracePeriodSeconds
+// ago. Threshold checks are skipped then since early values are often
+// partial; NaN/Inf values are still acted on.
+func inGracePeriod(run types.Run, config config.Config) bool {
+	if config.GracePeriodSeconds <= 0 || run.Info.StartTime == 0 {
+		return false
 	}
 
-	var runsResponse types.GetRunsResponse
-	if err := json.Unmarshal(body, &runsResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %v", err)
+	started := time.UnixMilli(run.Info.StartTime)
+	return time.Since(started) < time.Duration(config.GracePeriodSeconds)*time.Second
+}
+
+// GetExperimentIDByName resolves the ID of the experiment called name.
+func GetExperimentIDByName(client MLflowClient, name string) (string, error) {
+	log.Debug().Str("experiment_name", name).Msg("looking up experiment by name")
+
+	experiment, err := client.GetExperimentByName(name)
+	if err != nil {
+		return "", err
 	}
 
-	return &runsResponse, nil
+	return experiment.Experiment.ExperimentID, nil
 }
 
-func getAllActiveRuns(config config.Config, debug bool) (*types.GetRunsResponse, error) {
-	endpoint := fmt.Sprintf("%s/api/2.0/mlflow/runs/search", config.MLflowTrackingURI)
+func getActiveRunsInExperiment(client MLflowClient, experimentID string, config config.Config) (*types.GetRunsResponse, error) {
+	log.Debug().Str("experiment_id", experimentID).Msg("searching for active runs in experiment")
 
-	if debug {
-		log.Printf("Debug: Searching for active runs at: %s", endpoint)
+	runsResponse, err := client.SearchRuns(types.SearchRunsRequest{
+		ExperimentIDs: []string{experimentID},
+		Filter:        config.ActiveRunsFilter,
+		RunViewType:   "ACTIVE_ONLY",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch active runs: %w", err)
 	}
 
-	requestBodies := []string{
-		`{"filter": "attributes.status = 'RUNNING'"}`,
-		`{"filter": "status = 'RUNNING'"}`,
-		`{"run_view_type": "ACTIVE_ONLY"}`,
+	runsResponse.Runs = scopeRuns(runsResponse.Runs, config)
+	return runsResponse, nil
+}
+
+func getAllRuns(client MLflowClient) (*types.GetRunsResponse, error) {
+	log.Debug().Msg("searching for all runs")
+
+	runsResponse, err := client.SearchRuns(types.SearchRunsRequest{MaxResults: 100})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch all runs: %w", err)
 	}
 
-	for i, requestBody := range requestBodies {
-		if debug {
-			log.Printf("Debug: Trying request format %d: %s", i+1, requestBody)
-		}
+	return runsResponse, nil
+}
 
-		resp, err := http.Post(endpoint, "application/json", strings.NewReader(requestBody))
-		if err != nil {
-			if debug {
-				log.Printf("Debug: Request format %d failed with error: %v", i+1, err)
-			}
-			continue
-		}
+func getAllActiveRuns(client MLflowClient, config config.Config) (*types.GetRunsResponse, error) {
+	log.Debug().Str("filter", config.ActiveRunsFilter).Msg("searching for active runs")
 
-		defer resp.Body.Close()
+	runsResponse, err := client.SearchRuns(types.SearchRunsRequest{
+		Filter:      config.ActiveRunsFilter,
+		RunViewType: "ACTIVE_ONLY",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch active runs: %w", err)
+	}
 
-		if debug {
-			log.Printf("Debug: Request format %d response status: %s", i+1, resp.Status)
-		}
+	log.Debug().Int("count", len(runsResponse.Runs)).Msg("found active runs")
 
-		if resp.StatusCode != http.StatusOK {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			if debug {
-				log.Printf("Debug: Request format %d failed with status %d: %s",
-					i+1, resp.StatusCode, string(bodyBytes))
-			}
-			continue
-		}
+	runsResponse.Runs = scopeRuns(runsResponse.Runs, config)
+	return runsResponse, nil
+}
 
-		body, err := io.ReadAll(resp.Body)
+// searchAllRuns runs request and follows next_page_token until every page of
+// results has been fetched.
+func searchAllRuns(client MLflowClient, request types.SearchRunsRequest) ([]types.Run, error) {
+	var runs []types.Run
+	for {
+		response, err := client.SearchRuns(request)
 		if err != nil {
-			if debug {
-				log.Printf("Debug: Failed to read response body for format %d: %v", i+1, err)
-			}
-			continue
+			return nil, err
 		}
+		runs = append(runs, response.Runs...)
 
-		var runsResponse types.GetRunsResponse
-		if err := json.Unmarshal(body, &runsResponse); err != nil {
-			if debug {
-				log.Printf("Debug: Failed to parse response for format %d: %v", i+1, err)
-			}
-			continue
+		if response.NextPageToken == "" {
+			return runs, nil
 		}
+		request.PageToken = response.NextPageToken
+	}
+}
 
-		if len(runsResponse.Runs) > 0 {
-			if debug {
-				log.Printf("Debug: Successfully found %d active runs using format %d",
-					len(runsResponse.Runs), i+1)
-			}
-			return &runsResponse, nil
-		}
+// stopRun marks runID as FAILED or, when action is StopActionDelete, deletes
+// it. The status is re-read first since the run may have finished between the
+// check and the stop; a FINISHED run must not be overwritten, so
+// ErrRunAlreadyTerminal is returned instead. The same goes for a run that was
+// deleted meanwhile.
+func stopRun(client MLflowClient, runID string, action string) error {
+	log.Debug().Str("run_id", runID).Msg("stopping run")
+
+	current, err := client.GetRun(runID)
+	if err != nil {
+		return fmt.Errorf("failed to check run status before stopping: %w", err)
+	}
+	if status := current.Run.Info.Status; status != "RUNNING" {
+		return fmt.Errorf("%w: status is %s", ErrRunAlreadyTerminal, status)
+	}
+	if current.Run.Info.LifecycleStage == lifecycleDeleted {
+		return fmt.Errorf("%w: run is deleted", ErrRunAlreadyTerminal)
+	}
 
-		if debug {
-			log.Printf("Debug: Request format %d returned 0 active runs", i+1)
+	if action == config.StopActionDelete {
+		if err := client.DeleteRun(runID); err != nil {
+			return fmt.Errorf("failed to stop run: %w", err)
 		}
+		log.Info().Str("run_id", runID).Msg("successfully deleted run")
+		return nil
 	}
 
-	return &types.GetRunsResponse{Runs: []struct {
-		Info types.RunInfo `json:"info"`
-		Data struct {
-			Metrics []types.Metric `json:"metrics"`
-		} `json:"data"`
-	}{}}, nil
-}
+	if err := client.UpdateRun(runID, "FAILED"); err != nil {
+		return fmt.Errorf("failed to stop run: %w", err)
+	}
 
-func stopRun(runID string, config config.Config, debug bool) error {
-	endpoint := fmt.Sprintf("%s/api/2.0/mlflow/runs/update", config.MLflowTrackingURI)
+	log.Info().Str("run_id", runID).Msg("successfully stopped run")
+	return nil
+}
 
-	if debug {
-		log.Printf("Debug: Stopping run %s at: %s", runID, endpoint)
+// delayStop tells the training script behind runID that it is about to be
+// stopped and waits StopDelaySeconds for it to flush its final artifacts.
+// The wait happens even if the tag cannot be set; the script may still be
+// finishing on its own.
+func delayStop(client MLflowClient, runID string, config config.Config) {
+	if err := setRunTag(client, runID, stoppingTag, "true"); err != nil {
+		log.Error().Err(err).Str("run_id", runID).Msg("failed to set stopping tag")
 	}
 
-	requestBody := fmt.Sprintf(`{
-		"run_id": "%s",
-		"status": "FAILED"
-	}`, runID)
+	delay := time.Duration(config.StopDelaySeconds) * time.Second
+	log.Info().Str("run_id", runID).Dur("delay", delay).Msg("waiting before stopping run")
+	sleep(delay)
+}
 
-	resp, err := http.Post(endpoint, "application/json", strings.NewReader(requestBody))
-	if err != nil {
-		return fmt.Errorf("failed to stop run: %v", err)
+// setRunTag sets a single tag on runID.
+func setRunTag(client MLflowClient, runID string, key string, value string) error {
+	log.Debug().Str("run_id", runID).Str("key", key).Msg("setting run tag")
+
+	if err := client.SetTag(runID, key, value); err != nil {
+		return fmt.Errorf("failed to set tag %s: %w", key, err)
 	}
-	defer resp.Body.Close()
+	return nil
+}
+
+// logRunMetric logs value for key on runID at step, timestamped now.
+func logRunMetric(client MLflowClient, runID string, key string, value float64, step int) error {
+	log.Debug().Str("run_id", runID).Str("key", key).Float64("value", value).Msg("logging run metric")
 
-	if debug {
-		log.Printf("Debug: Stop run API response status: %s", resp.Status)
+	if err := client.LogMetric(runID, key, value, time.Now().UnixMilli(), step); err != nil {
+		return fmt.Errorf("failed to log metric %s: %w", key, err)
 	}
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("MLflow API returned status code %d: %s",
-			resp.StatusCode, string(bodyBytes))
+// writeStopNote records why runID was stopped as a tag and as the run's note,
+// which the MLflow UI shows on the run page. Failures are logged only; the
+// run has already been stopped. Nothing is written when action deleted the
+// run, since MLflow does not accept tags on a deleted run.
+func writeStopNote(client MLflowClient, runID string, v violation, action string) {
+	if action == config.StopActionDelete {
+		return
 	}
 
-	log.Printf("Successfully stopped run %s", runID)
-	return nil
+	note := fmt.Sprintf("Stopped by mlflow-autostop at %s.\n\n%s",
+		time.Now().UTC().Format(time.RFC3339), v.Reason)
+
+	tags := []struct{ key, value string }{
+		{stopReasonTag, v.Reason},
+		{noteTag, note},
+	}
+	for _, tag := range tags {
+		if err := setRunTag(client, runID, tag.key, tag.value); err != nil {
+			log.Error().Err(err).Str("run_id", runID).Msg("failed to record stop reason")
+		}
+	}
 }
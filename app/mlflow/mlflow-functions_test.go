@@ -0,0 +1,1551 @@
+package mlflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/events"
+	"github.com/gidra39/mlflow-autostop/state"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubMLflow is a minimal MLflow tracking server serving a single canned run
+// and recording every runs/update request it receives.
+type stubMLflow struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	run           types.Run
+	history       map[string][]types.Metric
+	gets          int
+	updates       []map[string]string
+	deletes       []string
+	tags          []map[string]string
+	logged        []map[string]interface{}
+	notifications int
+	// failUpdates makes runs/update answer with a server error.
+	failUpdates bool
+}
+
+func newStubMLflow(t *testing.T, run types.Run) *stubMLflow {
+	t.Helper()
+
+	stub := &stubMLflow{run: run}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/2.0/mlflow/runs/get", func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.gets++
+		writeJSON(t, w, types.GetRunResponse{Run: stub.run})
+	})
+
+	mux.HandleFunc("/api/2.0/mlflow/runs/search", func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		writeJSON(t, w, types.GetRunsResponse{Runs: []types.Run{stub.run}})
+	})
+
+	mux.HandleFunc("/api/2.0/mlflow/metrics/get-history", func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		writeJSON(t, w, types.GetMetricHistoryResponse{Metrics: stub.history[r.URL.Query().Get("metric_key")]})
+	})
+
+	mux.HandleFunc("/api/2.0/mlflow/runs/update", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode update body: %v", err)
+		}
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		if stub.failUpdates {
+			http.Error(w, `{"error_code": "INTERNAL_ERROR"}`, http.StatusInternalServerError)
+			return
+		}
+		stub.updates = append(stub.updates, body)
+		if body["run_id"] == stub.run.Info.RunID {
+			stub.run.Info.Status = body["status"]
+		}
+		writeJSON(t, w, map[string]interface{}{})
+	})
+
+	mux.HandleFunc("/api/2.0/mlflow/runs/delete", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode delete body: %v", err)
+		}
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.deletes = append(stub.deletes, body["run_id"])
+		writeJSON(t, w, map[string]interface{}{})
+	})
+
+	mux.HandleFunc("/api/2.0/mlflow/runs/set-tag", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode set-tag body: %v", err)
+		}
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.tags = append(stub.tags, body)
+		writeJSON(t, w, map[string]interface{}{})
+	})
+
+	mux.HandleFunc("/api/2.0/mlflow/runs/log-metric", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode log-metric body: %v", err)
+		}
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.logged = append(stub.logged, body)
+		writeJSON(t, w, map[string]interface{}{})
+	})
+
+	// Stands in for the Slack webhook so notifications never leave the test.
+	mux.HandleFunc("/slack", func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.notifications++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	stub.Server = httptest.NewServer(mux)
+	t.Cleanup(stub.Close)
+
+	return stub
+}
+
+func (s *stubMLflow) config(thresholds map[string]config.Threshold) config.Config {
+	return config.Config{
+		MLflowTrackingURI: s.URL,
+		PollInterval:      1,
+		MetricThresholds:  thresholds,
+		SlackWebhookURL:   s.URL + "/slack",
+		MessageChannels:   "SLACK",
+		ActiveRunsFilter:  "attributes.status = 'RUNNING'",
+		StopOnNaN:         true,
+	}
+}
+
+func (s *stubMLflow) recordedUpdates() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]string(nil), s.updates...)
+}
+
+func newTestClient(t *testing.T, cfg config.Config) MLflowClient {
+	t.Helper()
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		t.Errorf("failed to encode response: %v", err)
+	}
+}
+
+func runningRun(runID string, metrics ...types.Metric) types.Run {
+	return types.Run{
+		Info: types.RunInfo{RunID: runID, Status: "RUNNING", ExperimentID: "1"},
+		Data: types.RunData{Metrics: metrics},
+	}
+}
+
+func TestMetricViolation(t *testing.T) {
+	tests := []struct {
+		name      string
+		metric    types.Metric
+		stopOnNaN bool
+		want      bool
+	}{
+		{"above threshold", types.Metric{Key: "loss", Value: 5.1}, true, true},
+		{"equal to threshold", types.Metric{Key: "loss", Value: 5}, true, false},
+		{"below threshold", types.Metric{Key: "loss", Value: 1}, true, false},
+		{"no threshold configured", types.Metric{Key: "accuracy", Value: 100}, true, false},
+		{"NaN stops", types.Metric{Key: "accuracy", Value: math.NaN()}, true, true},
+		{"Inf stops", types.Metric{Key: "loss", Value: math.Inf(-1)}, true, true},
+		{"NaN ignored when disabled", types.Metric{Key: "loss", Value: math.NaN()}, false, false},
+		{"inside band", types.Metric{Key: "lr", Value: 1}, true, false},
+		{"below band", types.Metric{Key: "lr", Value: 0.05}, true, true},
+		{"above band", types.Metric{Key: "lr", Value: 11}, true, true},
+	}
+	lrMin, lrMax := 0.1, 10.0
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{
+				MetricThresholds: map[string]config.Threshold{
+					"loss": config.MaxThreshold(5),
+					"lr":   {Min: &lrMin, Max: &lrMax},
+				},
+				StopOnNaN: tt.stopOnNaN,
+			}
+
+			v, got := metricViolation(types.Run{Info: types.RunInfo{RunID: "run-1"}}, tt.metric, cfg)
+			if got != tt.want {
+				t.Fatalf("metricViolation() = %v (%q), want %v", got, v.Reason, tt.want)
+			}
+			if got && v.Reason == "" {
+				t.Fatal("metricViolation() returned an empty reason for a violation")
+			}
+		})
+	}
+}
+
+func TestMetricViolationAppliesThresholdEpsilon(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric types.Metric
+		want   bool
+	}{
+		{"above max within epsilon", types.Metric{Key: "loss", Value: 5.005}, false},
+		{"above max beyond epsilon", types.Metric{Key: "loss", Value: 5.02}, true},
+		{"below min within epsilon", types.Metric{Key: "lr", Value: 0.095}, false},
+		{"below min beyond epsilon", types.Metric{Key: "lr", Value: 0.08}, true},
+	}
+	lrMin := 0.1
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{
+				MetricThresholds: map[string]config.Threshold{
+					"loss": config.MaxThreshold(5),
+					"lr":   {Min: &lrMin},
+				},
+				ThresholdEpsilon: 0.01,
+			}
+
+			v, got := metricViolation(types.Run{Info: types.RunInfo{RunID: "run-1"}}, tt.metric, cfg)
+			if got != tt.want {
+				t.Errorf("metricViolation() = %v (%q), want %v", got, v.Reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRunMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		metrics  []types.Metric
+		wantStop bool
+	}{
+		{"within thresholds", []types.Metric{{Key: "loss", Value: 0.5}}, false},
+		{"threshold crossed", []types.Metric{{Key: "loss", Value: 2.5}}, true},
+		{"unrelated metric", []types.Metric{{Key: "accuracy", Value: 2.5}}, false},
+		{"no metrics yet", nil, false},
+		{"empty metrics", []types.Metric{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubMLflow(t, runningRun("run-1", tt.metrics...))
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+
+			result := checkRunMetrics(newTestClient(t, cfg), "run-1", cfg)
+			if (result.Stopped == 1) != tt.wantStop {
+				t.Errorf("checkRunMetrics() = %+v, wantStop %v", result, tt.wantStop)
+			}
+
+			updates := stub.recordedUpdates()
+			if tt.wantStop && len(updates) != 1 {
+				t.Fatalf("expected one stop request, got %d", len(updates))
+			}
+			if !tt.wantStop && len(updates) != 0 {
+				t.Fatalf("expected no stop requests, got %v", updates)
+			}
+		})
+	}
+}
+
+func TestMonitorSpecificRunReturnsWhenRunNotRunning(t *testing.T) {
+	run := runningRun("run-1", types.Metric{Key: "loss", Value: 100})
+	run.Info.Status = "FINISHED"
+
+	stub := newStubMLflow(t, run)
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+
+	result := MonitorSpecificRun(context.Background(), newTestClient(t, cfg), "run-1", cfg)
+	if result != (PollResult{}) {
+		t.Errorf("MonitorSpecificRun() = %+v, want an empty result", result)
+	}
+
+	if updates := stub.recordedUpdates(); len(updates) != 0 {
+		t.Fatalf("finished run must not be stopped, got %v", updates)
+	}
+}
+
+func TestPollExperimentCountsStops(t *testing.T) {
+	stub := newStubMLflow(t, runningRun("run-1", types.Metric{Key: "loss", Value: 3}))
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+
+	result := PollExperiment(newTestClient(t, cfg), "1", cfg)
+
+	want := PollResult{Checked: 1, Stopped: 1}
+	if result != want {
+		t.Errorf("PollExperiment() = %+v, want %+v", result, want)
+	}
+}
+
+func TestPollExperimentUsesSearchMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		metrics  []types.Metric
+		wantGets int
+	}{
+		{"metrics embedded in search", []types.Metric{{Key: "loss", Value: 0.5}}, 0},
+		{"falls back to runs/get", nil, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubMLflow(t, runningRun("run-1", tt.metrics...))
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+
+			PollExperiment(newTestClient(t, cfg), "1", cfg)
+
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if stub.gets != tt.wantGets {
+				t.Errorf("runs/get called %d times, want %d", stub.gets, tt.wantGets)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunRespectsGracePeriod(t *testing.T) {
+	tests := []struct {
+		name      string
+		startedAt time.Time
+		value     float64
+		wantStop  bool
+	}{
+		{"young run is skipped", time.Now().Add(-10 * time.Second), 5, false},
+		{"old run is checked", time.Now().Add(-2 * time.Minute), 5, true},
+		{"young run with NaN is stopped", time.Now().Add(-10 * time.Second), math.NaN(), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubMLflow(t, runningRun("run-1"))
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+			cfg.GracePeriodSeconds = 60
+
+			run := runningRun("run-1", types.Metric{Key: "loss", Value: tt.value})
+			run.Info.StartTime = tt.startedAt.UnixMilli()
+
+			if got := evaluateRun(newTestClient(t, cfg), run, cfg).Stopped > 0; got != tt.wantStop {
+				t.Errorf("evaluateRun() = %v, want %v", got, tt.wantStop)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunSmoothsMetric(t *testing.T) {
+	history := []types.Metric{
+		{Key: "loss", Value: 6, Step: 3},
+		{Key: "loss", Value: 1, Step: 1},
+		{Key: "loss", Value: 2, Step: 2},
+		{Key: "loss", Value: 100, Step: 0},
+	}
+
+	tests := []struct {
+		name     string
+		window   int
+		wantStop bool
+	}{
+		{"no smoothing uses latest value", 1, true},
+		{"mean of last three steps stays below", 3, false},
+		{"window larger than history uses all points", 10, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubMLflow(t, runningRun("run-1"))
+			stub.history = map[string][]types.Metric{"loss": history}
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(5)})
+			cfg.SmoothingWindow = map[string]int{"loss": tt.window}
+
+			run := runningRun("run-1", types.Metric{Key: "loss", Value: 6, Step: 3})
+			if got := evaluateRun(newTestClient(t, cfg), run, cfg).Stopped > 0; got != tt.wantStop {
+				t.Errorf("evaluateRun() = %v, want %v", got, tt.wantStop)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunAggregatesMetricWindow(t *testing.T) {
+	history := []types.Metric{
+		{Key: "grad_norm", Value: 20, Step: 1},
+		{Key: "grad_norm", Value: 150, Step: 2},
+		{Key: "grad_norm", Value: 10, Step: 3},
+	}
+
+	tests := []struct {
+		aggregation string
+		wantStop    bool
+	}{
+		{"last", false},
+		{"max", true},
+		{"min", false},
+		{"mean", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.aggregation, func(t *testing.T) {
+			stub := newStubMLflow(t, runningRun("run-1"))
+			stub.history = map[string][]types.Metric{"grad_norm": history}
+			cfg := stub.config(map[string]config.Threshold{"grad_norm": config.MaxThreshold(100)})
+			cfg.SmoothingWindow = map[string]int{"grad_norm": 3}
+			cfg.MetricAggregation = map[string]string{"grad_norm": tt.aggregation}
+
+			run := runningRun("run-1", types.Metric{Key: "grad_norm", Value: 10, Step: 3})
+			if got := evaluateRun(newTestClient(t, cfg), run, cfg).Stopped > 0; got != tt.wantStop {
+				t.Errorf("evaluateRun() stopped = %v, want %v", got, tt.wantStop)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunWarnsOncePerCrossing(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+	stub := newStubMLflow(t, runningRun("run-warn"))
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(10)})
+	cfg.WarnThresholds = map[string]config.Threshold{"loss": config.MaxThreshold(5)}
+	client := newTestClient(t, cfg)
+
+	polls := []struct {
+		value             float64
+		wantNotifications int
+	}{
+		{6, 1}, // crosses the warning threshold
+		{7, 1}, // still above, already warned
+		{4, 1}, // back within bounds clears the warning
+		{8, 2}, // crosses again
+	}
+
+	for i, poll := range polls {
+		run := runningRun("run-warn", types.Metric{Key: "loss", Value: poll.value})
+		if evaluateRun(client, run, cfg).Stopped > 0 {
+			t.Fatalf("poll %d: warning threshold must not stop the run", i)
+		}
+
+		stub.mu.Lock()
+		got := stub.notifications
+		stub.mu.Unlock()
+		if got != poll.wantNotifications {
+			t.Errorf("poll %d: %d notifications sent, want %d", i, got, poll.wantNotifications)
+		}
+	}
+
+	if updates := stub.recordedUpdates(); len(updates) != 0 {
+		t.Errorf("expected no stop requests, got %v", updates)
+	}
+}
+
+func TestStopRunSendsFailedStatus(t *testing.T) {
+	stub := newStubMLflow(t, runningRun("run-1"))
+	cfg := stub.config(nil)
+
+	if err := stopRun(newTestClient(t, cfg), "run-1", config.StopActionUpdate); err != nil {
+		t.Fatalf("stopRun() error = %v", err)
+	}
+
+	updates := stub.recordedUpdates()
+	if len(updates) != 1 {
+		t.Fatalf("expected one update request, got %d", len(updates))
+	}
+
+	want := map[string]string{"run_id": "run-1", "status": "FAILED"}
+	for key, value := range want {
+		if updates[0][key] != value {
+			t.Errorf("update body %s = %q, want %q", key, updates[0][key], value)
+		}
+	}
+}
+
+func TestStopRunDeletesWithDeleteAction(t *testing.T) {
+	stub := newStubMLflow(t, runningRun("run-1"))
+	cfg := stub.config(nil)
+
+	if err := stopRun(newTestClient(t, cfg), "run-1", config.StopActionDelete); err != nil {
+		t.Fatalf("stopRun() error = %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.deletes) != 1 || stub.deletes[0] != "run-1" || len(stub.updates) != 0 {
+		t.Errorf("deletes = %v, updates = %v, want run-1 deleted and not updated", stub.deletes, stub.updates)
+	}
+}
+
+func TestStopRunLeavesTerminalRunUnchanged(t *testing.T) {
+	run := runningRun("run-1")
+	run.Info.Status = "FINISHED"
+	stub := newStubMLflow(t, run)
+	cfg := stub.config(nil)
+
+	err := stopRun(newTestClient(t, cfg), "run-1", config.StopActionUpdate)
+	if !errors.Is(err, ErrRunAlreadyTerminal) {
+		t.Fatalf("stopRun() error = %v, want ErrRunAlreadyTerminal", err)
+	}
+	if updates := stub.recordedUpdates(); len(updates) != 0 {
+		t.Errorf("expected no update request for a finished run, got %v", updates)
+	}
+}
+
+func TestNextIdleInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		current time.Duration
+		max     int
+		want    time.Duration
+	}{
+		{"no cap keeps interval", 10 * time.Second, 0, 10 * time.Second},
+		{"doubles below cap", 10 * time.Second, 60, 20 * time.Second},
+		{"clamped to cap", 40 * time.Second, 60, 60 * time.Second},
+		{"stays at cap", 60 * time.Second, 60, 60 * time.Second},
+		{"cap below base keeps base", 30 * time.Second, 10, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{MaxIdleIntervalSeconds: tt.max}
+			if got := nextIdleInterval(tt.current, cfg); got != tt.want {
+				t.Errorf("nextIdleInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetAllActiveRunsReportsSearchFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantErr  bool
+		wantRuns int
+	}{
+		{"server error is an error", http.StatusInternalServerError, `{"error_code":"INTERNAL_ERROR"}`, true, 0},
+		{"bad request is an error", http.StatusBadRequest, `{"error_code":"INVALID_PARAMETER_VALUE"}`, true, 0},
+		{"empty result is not an error", http.StatusOK, `{}`, false, 0},
+		{"runs are returned", http.StatusOK, `{"runs":[{"info":{"run_id":"run-1","status":"RUNNING"}}]}`, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			cfg := config.Config{MLflowTrackingURI: server.URL}
+			runs, err := getAllActiveRuns(newTestClient(t, cfg), cfg)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %d runs", len(runs.Runs))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(runs.Runs) != tt.wantRuns {
+				t.Errorf("got %d runs, want %d", len(runs.Runs), tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestGetExperimentIDByName(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/2.0/mlflow/experiments/get-by-name" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("experiment_name") != "churn model" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error_code":"RESOURCE_DOES_NOT_EXIST"}`))
+			return
+		}
+		writeJSON(t, w, types.GetExperimentResponse{Experiment: types.Experiment{ExperimentID: "42", Name: "churn model"}})
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, config.Config{MLflowTrackingURI: server.URL})
+
+	id, err := GetExperimentIDByName(client, "churn model")
+	if err != nil || id != "42" {
+		t.Errorf("GetExperimentIDByName() = %q, %v, want \"42\", nil", id, err)
+	}
+
+	_, err = GetExperimentIDByName(client, "missing")
+	if !errors.Is(err, ErrExperimentNotFound) || !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("GetExperimentIDByName() error = %v, want a does-not-exist error", err)
+	}
+}
+
+func TestWaitForTickSkipsOverrunTick(t *testing.T) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	// Simulate a poll that took longer than the interval.
+	time.Sleep(120 * time.Millisecond)
+
+	start := time.Now()
+	waitForTick(context.Background(), ticker, nil)
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond {
+		t.Errorf("waitForTick() returned after %v, want it to wait for the next tick", elapsed)
+	}
+}
+
+func TestWaitForTickReturnsOnPollNow(t *testing.T) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	wake, unsubscribe := subscribePollNow()
+	defer unsubscribe()
+
+	PollNow()
+	PollNow() // merged with the pending request
+
+	done := make(chan struct{})
+	go func() {
+		waitForTick(context.Background(), ticker, wake)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waitForTick() did not return after PollNow")
+	}
+
+	select {
+	case <-wake:
+		t.Error("repeated PollNow calls should leave at most one pending poll")
+	default:
+	}
+}
+
+func TestWaitForTickReturnsFalseOnCancel(t *testing.T) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if waitForTick(ctx, ticker, nil) {
+		t.Error("waitForTick() = true, want false once the context is cancelled")
+	}
+}
+
+func TestCheckRunsPreservesLatestRun(t *testing.T) {
+	stub := newStubMLflow(t, runningRun("unused"))
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+	cfg.PreserveLatest = true
+
+	older := runningRun("older", types.Metric{Key: "loss", Value: 5})
+	older.Info.StartTime = 1000
+	newest := runningRun("newest", types.Metric{Key: "loss", Value: 5})
+	newest.Info.StartTime = 2000
+
+	result := checkRuns(newTestClient(t, cfg), []types.Run{newest, older}, cfg)
+
+	if result.Checked != 2 || result.Stopped != 1 {
+		t.Errorf("result = %+v, want 2 checked and 1 stopped", result)
+	}
+	updates := stub.recordedUpdates()
+	if len(updates) != 1 || updates[0]["run_id"] != "older" {
+		t.Errorf("updates = %v, want only the older run stopped", updates)
+	}
+}
+
+func TestCheckRunsCountsWarnedRuns(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+	stub := newStubMLflow(t, runningRun("unused"))
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(10)})
+	cfg.WarnThresholds = map[string]config.Threshold{"loss": config.MaxThreshold(5)}
+
+	runs := []types.Run{
+		runningRun("calm", types.Metric{Key: "loss", Value: 1}),
+		runningRun("warned", types.Metric{Key: "loss", Value: 6}),
+		runningRun("stopped", types.Metric{Key: "loss", Value: 11}),
+	}
+
+	result := checkRuns(newTestClient(t, cfg), runs, cfg)
+
+	want := PollResult{Checked: 3, Warned: 1, Stopped: 1}
+	if result != want {
+		t.Errorf("checkRuns() = %+v, want %+v", result, want)
+	}
+	if got := result.String(); got != "3 checked, 1 warned, 1 stopped, 0 errors" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestEvaluateRunRequireNotification(t *testing.T) {
+	tests := []struct {
+		name        string
+		require     bool
+		wantUpdates int
+	}{
+		{"stops despite failed notification by default", false, 1},
+		{"keeps run when notification is required", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubMLflow(t, runningRun("run-1"))
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+			cfg.SlackWebhookURL = stub.URL + "/missing"
+			cfg.RequireNotification = tt.require
+
+			run := runningRun("run-1", types.Metric{Key: "loss", Value: 5})
+			result := evaluateRun(newTestClient(t, cfg), run, cfg)
+
+			if got := len(stub.recordedUpdates()); got != tt.wantUpdates {
+				t.Errorf("got %d stop requests, want %d", got, tt.wantUpdates)
+			}
+			if tt.require && (result.Stopped != 0 || result.Errors != 1) {
+				t.Errorf("result = %+v, want the undelivered stop counted as an error", result)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunWritesStopNote(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		stub := newStubMLflow(t, runningRun("run-1"))
+		cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+		cfg.WriteStopNote = enabled
+
+		run := runningRun("run-1", types.Metric{Key: "loss", Value: 5})
+		evaluateRun(newTestClient(t, cfg), run, cfg)
+
+		stub.mu.Lock()
+		tags := map[string]string{}
+		for _, tag := range stub.tags {
+			tags[tag["key"]] = tag["value"]
+		}
+		stub.mu.Unlock()
+
+		if !enabled {
+			if len(tags) != 0 {
+				t.Errorf("WriteStopNote disabled: got tags %v, want none", tags)
+			}
+			continue
+		}
+		if !strings.Contains(tags[noteTag], "exceeded threshold") {
+			t.Errorf("note = %q, want it to explain the stop", tags[noteTag])
+		}
+		if tags[stopReasonTag] == "" {
+			t.Errorf("missing %s tag", stopReasonTag)
+		}
+	}
+}
+
+func TestEvaluateRunSkipsStopNoteOnDeletedRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		action    string
+		lifecycle string
+		wantStop  bool
+	}{
+		{"deleted by the stop", config.StopActionDelete, "", true},
+		{"already in the trash", config.StopActionUpdate, lifecycleDeleted, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStateStore(state.NewMemoryStore())
+			current := runningRun("run-1")
+			current.Info.LifecycleStage = tt.lifecycle
+			stub := newStubMLflow(t, current)
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+			cfg.WriteStopNote = true
+			cfg.StopAction = tt.action
+
+			run := runningRun("run-1", types.Metric{Key: "loss", Value: 5})
+			if got := evaluateRun(newTestClient(t, cfg), run, cfg).Stopped > 0; got != tt.wantStop {
+				t.Errorf("evaluateRun() stopped = %v, want %v", got, tt.wantStop)
+			}
+
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if len(stub.tags) != 0 {
+				t.Errorf("tags = %v, want no stop note on a deleted run", stub.tags)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunLogsStopMetric(t *testing.T) {
+	stub := newStubMLflow(t, runningRun("run-1"))
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+	cfg.LogStopMetric = true
+
+	run := runningRun("run-1", types.Metric{Key: "loss", Value: 5, Step: 42})
+	evaluateRun(newTestClient(t, cfg), run, cfg)
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if len(stub.logged) != 1 {
+		t.Fatalf("logged %d metrics, want 1", len(stub.logged))
+	}
+	got := stub.logged[0]
+	if got["key"] != stopMetric || got["value"] != 1.0 || got["step"] != 42.0 {
+		t.Errorf("logged metric = %v, want %s=1 at step 42", got, stopMetric)
+	}
+	if timestamp, _ := got["timestamp"].(float64); timestamp <= 0 {
+		t.Errorf("timestamp = %v, want the current time in milliseconds", got["timestamp"])
+	}
+}
+
+func TestExperimentPollInterval(t *testing.T) {
+	cfg := config.Config{PollInterval: 30, ExperimentPollIntervals: map[string]int{"fast": 10}}
+
+	if got := experimentPollInterval("fast", cfg); got != 10*time.Second {
+		t.Errorf("override interval = %v, want 10s", got)
+	}
+	if got := experimentPollInterval("nightly", cfg); got != 30*time.Second {
+		t.Errorf("fallback interval = %v, want the global 30s", got)
+	}
+}
+
+func TestSyncTrackedRunsEmitsTransitions(t *testing.T) {
+	trackedRuns.Range(func(key, _ interface{}) bool {
+		trackedRuns.Delete(key)
+		return true
+	})
+
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event events.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		mu.Lock()
+		received = append(received, event.Type+":"+event.RunID)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	cfg := config.Config{EventWebhookURL: server.URL}
+	syncTrackedRuns([]types.Run{runningRun("event-a"), runningRun("event-b")}, cfg)
+	syncTrackedRuns([]types.Run{runningRun("event-b")}, cfg)
+	syncTrackedRuns(nil, cfg)
+
+	want := []string{"run_started:event-a", "run_started:event-b", "run_finished:event-a", "run_finished:event-b"}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(received, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", received, want)
+	}
+}
+
+func TestSyncTrackedRunsPerServer(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+
+	var mu sync.Mutex
+	var received []string
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event events.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		mu.Lock()
+		received = append(received, event.Type+":"+event.RunID)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	first := newStubMLflow(t, runningRun("server-a"))
+	second := newStubMLflow(t, runningRun("server-b"))
+	for i := 0; i < 2; i++ {
+		for _, stub := range []*stubMLflow{first, second} {
+			cfg := stub.config(nil)
+			cfg.EventWebhookURL = webhook.URL
+			PollAllActiveRuns(newTestClient(t, cfg), cfg)
+		}
+	}
+
+	want := []string{"run_started:server-a", "run_started:server-b"}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(received, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", received, want)
+	}
+}
+
+func TestEvaluateRunSkipsRunBeingStopped(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+
+	run := runningRun("r1", types.Metric{Key: "loss", Value: 5})
+	stub := newStubMLflow(t, run)
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+	client := newTestClient(t, cfg)
+
+	stopping.Store("r1", struct{}{})
+	result := evaluateRun(client, run, cfg)
+	stopping.Delete("r1")
+
+	if result.Stopped != 0 || len(stub.recordedUpdates()) != 0 {
+		t.Fatalf("run being stopped elsewhere was stopped again: %+v", result)
+	}
+
+	if result := evaluateRun(client, run, cfg); result.Stopped != 1 {
+		t.Errorf("evaluateRun() stopped = %d after the in-flight stop finished, want 1", result.Stopped)
+	}
+}
+
+func TestMetricViolationAppliesTagOverride(t *testing.T) {
+	cfg := config.Config{MetricThresholds: config.Thresholds{"loss": config.MaxThreshold(1)}}
+	metric := types.Metric{Key: "loss", Value: 2}
+
+	tests := []struct {
+		name string
+		tags []types.RunTag
+		want bool
+	}{
+		{"no override", nil, true},
+		{"override raises threshold", []types.RunTag{{Key: "autostop.threshold.loss", Value: "3.0"}}, false},
+		{"override for another metric", []types.RunTag{{Key: "autostop.threshold.accuracy", Value: "3.0"}}, true},
+		{"invalid override ignored", []types.RunTag{{Key: "autostop.threshold.loss", Value: "high"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := types.Run{
+				Info: types.RunInfo{RunID: "run-1"},
+				Data: types.RunData{Tags: tt.tags},
+			}
+			if _, got := metricViolation(run, metric, cfg); got != tt.want {
+				t.Errorf("metricViolation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricViolationReadsParamThreshold(t *testing.T) {
+	cfg := config.Config{MetricThresholds: config.Thresholds{"loss": {MaxParam: "max_loss"}}}
+	metric := types.Metric{Key: "loss", Value: 2}
+
+	tests := []struct {
+		name   string
+		params []types.Param
+		want   bool
+	}{
+		{"below param", []types.Param{{Key: "max_loss", Value: "3"}}, false},
+		{"above param", []types.Param{{Key: "max_loss", Value: "1.5"}}, true},
+		{"param missing", nil, false},
+		{"param not a number", []types.Param{{Key: "max_loss", Value: "high"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := types.Run{
+				Info: types.RunInfo{RunID: "run-1"},
+				Data: types.RunData{Params: tt.params},
+			}
+			if _, got := metricViolation(run, metric, cfg); got != tt.want {
+				t.Errorf("metricViolation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailedStopCountsAsError(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+	run := runningRun("r1", types.Metric{Key: "loss", Value: 5})
+	stub := newStubMLflow(t, run)
+	stub.failUpdates = true
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+	client := newTestClient(t, cfg)
+
+	result := evaluateRun(client, run, cfg)
+	if result.Stopped != 0 || result.Errors != 1 {
+		t.Errorf("evaluateRun() = %+v, want no stop and 1 error", result)
+	}
+
+	result, active := PollSpecificRun(client, "r1", cfg)
+	if result.Stopped != 0 || !active {
+		t.Errorf("PollSpecificRun() = %+v, active %v, want the still running run kept active", result, active)
+	}
+}
+
+func TestEvaluateRunDelaysStopAfterTagging(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+
+	run := runningRun("r1", types.Metric{Key: "loss", Value: 5})
+	stub := newStubMLflow(t, run)
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+	cfg.StopDelaySeconds = 30
+
+	var waited time.Duration
+	originalSleep := sleep
+	sleep = func(d time.Duration) {
+		waited = d
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		if len(stub.tags) != 1 || stub.tags[0]["key"] != stoppingTag || stub.tags[0]["value"] != "true" {
+			t.Errorf("tags before the delay = %v, want a single %s=true", stub.tags, stoppingTag)
+		}
+		if len(stub.updates) != 0 {
+			t.Error("run status was updated before the stop delay")
+		}
+	}
+	defer func() { sleep = originalSleep }()
+
+	if result := evaluateRun(newTestClient(t, cfg), run, cfg); result.Stopped != 1 {
+		t.Fatalf("evaluateRun() stopped = %d, want 1", result.Stopped)
+	}
+	if waited != 30*time.Second {
+		t.Errorf("waited %v before stopping, want 30s", waited)
+	}
+	if updates := stub.recordedUpdates(); len(updates) != 1 {
+		t.Errorf("updates = %v, want the run stopped after the delay", updates)
+	}
+}
+
+func TestEvaluateRunRoutesStopNotificationByExperiment(t *testing.T) {
+	tests := []struct {
+		name              string
+		channels          map[string]string
+		wantNotifications int
+	}{
+		{"experiment override", map[string]string{"1": "SLACK"}, 1},
+		{"other experiment falls back", map[string]string{"2": "SLACK"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStateStore(state.NewMemoryStore())
+			run := runningRun("r1", types.Metric{Key: "loss", Value: 5})
+			stub := newStubMLflow(t, run)
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+			// The global channel points nowhere, so only the override reaches the stub.
+			cfg.MessageChannels = "TEAMS"
+			cfg.ExperimentChannels = tt.channels
+
+			if result := evaluateRun(newTestClient(t, cfg), run, cfg); result.Stopped != 1 {
+				t.Fatalf("evaluateRun() stopped = %d, want 1", result.Stopped)
+			}
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if stub.notifications != tt.wantNotifications {
+				t.Errorf("%d notifications sent to the stub, want %d", stub.notifications, tt.wantNotifications)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunRoutesWarningByExperiment(t *testing.T) {
+	tests := []struct {
+		name              string
+		channels          map[string]string
+		wantNotifications int
+	}{
+		{"experiment override", map[string]string{"1": "SLACK"}, 1},
+		{"other experiment falls back", map[string]string{"2": "SLACK"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStateStore(state.NewMemoryStore())
+			run := runningRun("r1", types.Metric{Key: "loss", Value: 6})
+			stub := newStubMLflow(t, run)
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(10)})
+			cfg.WarnThresholds = map[string]config.Threshold{"loss": config.MaxThreshold(5)}
+			cfg.MessageChannels = "TEAMS"
+			cfg.ExperimentChannels = tt.channels
+
+			if result := evaluateRun(newTestClient(t, cfg), run, cfg); result.Warned != 1 {
+				t.Fatalf("evaluateRun() warned = %d, want 1", result.Warned)
+			}
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			if stub.notifications != tt.wantNotifications {
+				t.Errorf("%d notifications sent to the stub, want %d", stub.notifications, tt.wantNotifications)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunSoftStop(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+
+	run := runningRun("r1", types.Metric{Key: "loss", Value: 5})
+	stub := newStubMLflow(t, run)
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+	cfg.SoftStopTag = "autostop.requested"
+	cfg.SoftStopGraceSeconds = 60
+	client := newTestClient(t, cfg)
+
+	for i := 0; i < 2; i++ {
+		if result := evaluateRun(client, run, cfg); result.Stopped != 0 {
+			t.Fatalf("poll %d: evaluateRun() stopped the run within the soft stop grace period", i)
+		}
+	}
+	if len(stub.recordedUpdates()) != 0 {
+		t.Fatal("run status was updated within the soft stop grace period")
+	}
+
+	stub.mu.Lock()
+	tags := append([]map[string]string(nil), stub.tags...)
+	stub.mu.Unlock()
+	if len(tags) != 1 || tags[0]["key"] != "autostop.requested" || tags[0]["value"] != "true" {
+		t.Fatalf("tags = %v, want a single autostop.requested=true", tags)
+	}
+
+	runState.Update("r1", func(s *state.RunState) {
+		s.StopRequestedAt = time.Now().Add(-2 * time.Minute)
+	})
+	if result := evaluateRun(client, run, cfg); result.Stopped != 1 {
+		t.Errorf("evaluateRun() stopped = %d after the grace period, want 1", result.Stopped)
+	}
+}
+
+func TestCheckRunsCapsStopsPerPoll(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+
+	stub := newStubMLflow(t, runningRun("unused"))
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+	cfg.MaxStopsPerPoll = 2
+
+	var runs []types.Run
+	for _, id := range []string{"r1", "r2", "r3", "r4"} {
+		runs = append(runs, runningRun(id, types.Metric{Key: "loss", Value: 5}))
+	}
+
+	result := checkRuns(newTestClient(t, cfg), runs, cfg)
+
+	if result.Checked != 4 || result.Stopped != 2 {
+		t.Errorf("result = %+v, want 4 checked and 2 stopped", result)
+	}
+	if updates := stub.recordedUpdates(); len(updates) != 2 {
+		t.Errorf("sent %d stop requests, want 2", len(updates))
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.notifications != 3 {
+		t.Errorf("sent %d notifications, want 2 stops and 1 limit warning", stub.notifications)
+	}
+}
+
+func TestStepViolation(t *testing.T) {
+	cfg := config.Config{StepLimits: map[string]int{"epoch": 200}}
+	run := types.RunInfo{RunID: "run-1"}
+
+	tests := []struct {
+		name   string
+		metric types.Metric
+		want   bool
+	}{
+		{"within limit", types.Metric{Key: "epoch", Value: 1, Step: 200}, false},
+		{"past limit", types.Metric{Key: "epoch", Value: 1, Step: 201}, true},
+		{"metric without limit", types.Metric{Key: "loss", Value: 1, Step: 5000}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, got := stepViolation(run, tt.metric, cfg)
+			if got != tt.want {
+				t.Fatalf("stepViolation() = %v, want %v", got, tt.want)
+			}
+			if got && !strings.Contains(v.Reason, "step 201") {
+				t.Errorf("reason = %q, want it to name the step", v.Reason)
+			}
+		})
+	}
+}
+
+func TestMissingMetricViolation(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+	started := time.Now().Add(-time.Hour).UnixMilli()
+	cfg := config.Config{RequiredMetrics: []string{"val_loss"}, MissingMetricTimeoutSeconds: 600}
+
+	never := runningRun("never", types.Metric{Key: "loss", Value: 1})
+	never.Info.StartTime = started
+	if v, violated := missingMetricViolation(never, cfg); !violated || v.Metric != "val_loss" || !v.Warning {
+		t.Errorf("never logged: violation = %+v, %v, want a val_loss warning", v, violated)
+	}
+
+	fresh := runningRun("fresh", types.Metric{Key: "val_loss", Value: 1, Timestamp: 1})
+	fresh.Info.StartTime = started
+	if _, violated := missingMetricViolation(fresh, cfg); violated {
+		t.Error("a metric observed for the first time should not be stale")
+	}
+
+	runState.Update("fresh", func(s *state.RunState) {
+		s.MetricSeen["val_loss"] = state.MetricSeen{Timestamp: 1, SeenAt: time.Now().Add(-time.Hour)}
+	})
+	if _, violated := missingMetricViolation(fresh, cfg); !violated {
+		t.Error("a metric not updated for an hour should be stale")
+	}
+
+	cfg.MissingMetricTimeoutSeconds = 0
+	if _, violated := missingMetricViolation(never, cfg); violated {
+		t.Error("a zero timeout should disable the check")
+	}
+}
+
+func TestEvaluateRunStopsOnMissingMetric(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+	stub := newStubMLflow(t, runningRun("run-1"))
+	cfg := stub.config(nil)
+	cfg.RequiredMetrics = []string{"val_loss"}
+	cfg.MissingMetricTimeoutSeconds = 600
+	cfg.StopOnMissingMetric = true
+
+	run := runningRun("run-1")
+	run.Info.StartTime = time.Now().Add(-time.Hour).UnixMilli()
+
+	if result := evaluateRun(newTestClient(t, cfg), run, cfg); result.Stopped != 1 {
+		t.Errorf("evaluateRun() = %+v, want the run without val_loss stopped", result)
+	}
+}
+
+func TestEvaluateRunSkipsRecentlyStoppedRun(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+	stub := newStubMLflow(t, runningRun("run-1"))
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+	cfg.RecentlyStoppedTTLSeconds = 600
+	client := newTestClient(t, cfg)
+
+	run := runningRun("run-1", types.Metric{Key: "loss", Value: 5})
+	evaluateRun(client, run, cfg)
+	// MLflow has not caught up yet and still returns the run as RUNNING.
+	result := evaluateRun(client, run, cfg)
+
+	if result.Stopped != 0 || len(stub.recordedUpdates()) != 1 {
+		t.Errorf("second evaluateRun() = %+v with %d updates, want the recently stopped run skipped",
+			result, len(stub.recordedUpdates()))
+	}
+}
+
+func TestEvaluateRunStopsOnComputedMetric(t *testing.T) {
+	tests := []struct {
+		name     string
+		metrics  []types.Metric
+		wantStop bool
+	}{
+		{"gap above threshold", []types.Metric{{Key: "train_loss", Value: 0.1}, {Key: "val_loss", Value: 0.9}}, true},
+		{"gap within threshold", []types.Metric{{Key: "train_loss", Value: 0.5}, {Key: "val_loss", Value: 0.7}}, false},
+		{"referenced metric missing", []types.Metric{{Key: "train_loss", Value: 0.1}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStateStore(state.NewMemoryStore())
+			stub := newStubMLflow(t, runningRun("run-1", tt.metrics...))
+			cfg := stub.config(map[string]config.Threshold{"overfit_gap": config.MaxThreshold(0.5)})
+			cfg.ComputedMetrics = map[string]string{"overfit_gap": "val_loss - train_loss"}
+
+			result := evaluateRun(newTestClient(t, cfg), runningRun("run-1", tt.metrics...), cfg)
+			if (result.Stopped == 1) != tt.wantStop {
+				t.Errorf("evaluateRun() = %+v, wantStop %v", result, tt.wantStop)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunWithinThresholdLogLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		quiet     bool
+		wantLevel string
+	}{
+		{"info by default", false, "info"},
+		{"debug when quiet", true, "debug"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			original := log.Logger
+			log.Logger = zerolog.New(&out).Level(zerolog.DebugLevel)
+			defer func() { log.Logger = original }()
+
+			stub := newStubMLflow(t, runningRun("run-1"))
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+			cfg.QuietWithinThreshold = tt.quiet
+
+			evaluateRun(newTestClient(t, cfg), runningRun("run-1", types.Metric{Key: "loss", Value: 0.5}), cfg)
+
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				var entry map[string]any
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("failed to decode log line %q: %v", line, err)
+				}
+				if entry["message"] == "run metrics are within acceptable thresholds" {
+					if entry["level"] != tt.wantLevel {
+						t.Errorf("level = %v, want %s", entry["level"], tt.wantLevel)
+					}
+					return
+				}
+			}
+			t.Fatalf("within-threshold line not logged, got:\n%s", out.String())
+		})
+	}
+}
+
+func TestEvaluateRunStopsOnCompositeScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		metrics  []types.Metric
+		wantStop bool
+	}{
+		// 0.7*loss + 0.3*(1-accuracy)
+		{"score above threshold", []types.Metric{{Key: "loss", Value: 0.6}, {Key: "accuracy", Value: 0.5}}, true},
+		{"score within threshold", []types.Metric{{Key: "loss", Value: 0.3}, {Key: "accuracy", Value: 0.9}}, false},
+		{"metric missing", []types.Metric{{Key: "loss", Value: 5}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStateStore(state.NewMemoryStore())
+			stub := newStubMLflow(t, runningRun("run-1", tt.metrics...))
+			cfg := stub.config(nil)
+			cfg.CompositeScores = map[string]config.CompositeScore{
+				"quality": {Weights: map[string]float64{"loss": 0.7, "accuracy": -0.3}, Bias: 0.3, Threshold: 0.5},
+			}
+
+			result := evaluateRun(newTestClient(t, cfg), runningRun("run-1", tt.metrics...), cfg)
+			if (result.Stopped == 1) != tt.wantStop {
+				t.Errorf("evaluateRun() = %+v, wantStop %v", result, tt.wantStop)
+			}
+		})
+	}
+}
+
+func TestPollNowWakesEveryLoop(t *testing.T) {
+	first, unsubscribeFirst := subscribePollNow()
+	defer unsubscribeFirst()
+	second, unsubscribeSecond := subscribePollNow()
+	defer unsubscribeSecond()
+
+	PollNow()
+
+	for i, wake := range []<-chan struct{}{first, second} {
+		select {
+		case <-wake:
+		default:
+			t.Errorf("loop %d was not woken by PollNow", i)
+		}
+	}
+}
+
+func TestEvaluateRunSkipsStaleMetrics(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+	stale := time.Now().Add(-30 * time.Minute).UnixMilli()
+	metrics := []types.Metric{{Key: "loss", Value: 5, Timestamp: stale}}
+
+	stub := newStubMLflow(t, runningRun("run-1", metrics...))
+	cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+	cfg.MaxMetricAgeSeconds = 600
+	cfg.NotifyStalledRuns = true
+	client := newTestClient(t, cfg)
+
+	first := evaluateRun(client, runningRun("run-1", metrics...), cfg)
+	second := evaluateRun(client, runningRun("run-1", metrics...), cfg)
+
+	if first.Stopped != 0 || len(stub.recordedUpdates()) != 0 {
+		t.Errorf("evaluateRun() = %+v, want the stale metric ignored", first)
+	}
+	if first.Warned != 1 || second.Warned != 1 {
+		t.Errorf("evaluateRun() warned %d then %d times, want the stalled run reported on both", first.Warned, second.Warned)
+	}
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.notifications != 1 {
+		t.Errorf("sent %d notifications, want the stalled run notified once", stub.notifications)
+	}
+}
+
+func TestEvaluateRunConfirmsViolationBeforeStopping(t *testing.T) {
+	tests := []struct {
+		name        string
+		fetchedLoss float64
+		wantStopped int
+	}{
+		{"violation persists", 5, 1},
+		{"violation gone on re-fetch", 0.5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStateStore(state.NewMemoryStore())
+			stub := newStubMLflow(t, runningRun("run-1", types.Metric{Key: "loss", Value: tt.fetchedLoss}))
+			cfg := stub.config(map[string]config.Threshold{"loss": config.MaxThreshold(1)})
+			cfg.ConfirmBeforeStop = true
+			client := newTestClient(t, cfg)
+
+			result := evaluateRun(client, runningRun("run-1", types.Metric{Key: "loss", Value: 5}), cfg)
+
+			if result.Stopped != tt.wantStopped || len(stub.recordedUpdates()) != tt.wantStopped {
+				t.Errorf("evaluateRun() = %+v with updates %v, want %d stopped", result, stub.recordedUpdates(), tt.wantStopped)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunStopsOnRegressionFromBest(t *testing.T) {
+	tests := []struct {
+		name        string
+		accuracy    float64
+		wantStopped int
+	}{
+		{"collapsed", 0.4, 1},
+		{"within tolerance", 0.85, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStateStore(state.NewMemoryStore())
+			run := runningRun("run-1", types.Metric{Key: "accuracy", Value: tt.accuracy})
+			stub := newStubMLflow(t, run)
+			stub.history = map[string][]types.Metric{
+				"accuracy": {{Key: "accuracy", Value: 0.5, Step: 1}, {Key: "accuracy", Value: 0.9, Step: 2}},
+			}
+			floor := 0.1
+			cfg := stub.config(map[string]config.Threshold{"accuracy": {Min: &floor}})
+			cfg.MaxRegressionPct = 20
+
+			result := evaluateRun(newTestClient(t, cfg), run, cfg)
+
+			if result.Stopped != tt.wantStopped {
+				t.Errorf("evaluateRun() = %+v, want %d stopped", result, tt.wantStopped)
+			}
+			if best, _ := bestValue("run-1", "accuracy"); best != 0.9 {
+				t.Errorf("best value = %v, want 0.9 from the history", best)
+			}
+		})
+	}
+}
+
+func TestEvaluateRunWaitsForThresholdPatience(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+	run := runningRun("run-1", types.Metric{Key: "loss", Value: 6})
+	stub := newStubMLflow(t, run)
+	threshold := config.MaxThreshold(5)
+	threshold.Patience = 3
+	cfg := stub.config(map[string]config.Threshold{"loss": threshold})
+	client := newTestClient(t, cfg)
+
+	var stopped []int
+	for i := 0; i < 3; i++ {
+		stopped = append(stopped, evaluateRun(client, run, cfg).Stopped)
+	}
+
+	if stopped[0] != 0 || stopped[1] != 0 || stopped[2] != 1 {
+		t.Errorf("stopped per poll = %v, want the run stopped on the third consecutive violation", stopped)
+	}
+}
+
+func TestMetricViolationSkipsWarmupSteps(t *testing.T) {
+	threshold := config.MaxThreshold(5)
+	threshold.WarmupSteps = 100
+	threshold.Severity = config.SeverityCritical
+	cfg := config.Config{MetricThresholds: config.Thresholds{"loss": threshold}}
+
+	run := runningRun("run-1")
+	if _, violated := metricViolation(run, types.Metric{Key: "loss", Value: 6, Step: 50}, cfg); violated {
+		t.Error("metricViolation() = true during warmup, want the threshold ignored")
+	}
+	v, violated := metricViolation(run, types.Metric{Key: "loss", Value: 6, Step: 100}, cfg)
+	if !violated || !v.Critical {
+		t.Errorf("metricViolation() = %+v, %v after warmup, want a critical violation", v, violated)
+	}
+}
+
+func TestEvaluateRunResolvesMetricAliases(t *testing.T) {
+	tests := []struct {
+		name     string
+		metrics  []types.Metric
+		window   int
+		wantStop bool
+	}{
+		{"alias takes the canonical threshold", []types.Metric{{Key: "validation_loss", Value: 6}}, 1, true},
+		{"second alias", []types.Metric{{Key: "val/loss", Value: 6}}, 1, true},
+		{"first listed alias wins", []types.Metric{{Key: "val/loss", Value: 2}, {Key: "validation_loss", Value: 6}}, 1, true},
+		{"canonical key wins over alias", []types.Metric{{Key: "val_loss", Value: 2}, {Key: "validation_loss", Value: 6}}, 1, false},
+		{"history fetched under the alias", []types.Metric{{Key: "validation_loss", Value: 6, Step: 3}}, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStateStore(state.NewMemoryStore())
+			run := runningRun("run-1", tt.metrics...)
+			stub := newStubMLflow(t, run)
+			stub.history = map[string][]types.Metric{"validation_loss": {
+				{Key: "validation_loss", Value: 1, Step: 1},
+				{Key: "validation_loss", Value: 2, Step: 2},
+				{Key: "validation_loss", Value: 6, Step: 3},
+			}}
+			cfg := stub.config(map[string]config.Threshold{"val_loss": config.MaxThreshold(5)})
+			cfg.MetricAliases = map[string][]string{"val_loss": {"validation_loss", "val/loss"}}
+			cfg.SmoothingWindow = map[string]int{"val_loss": tt.window}
+
+			if got := evaluateRun(newTestClient(t, cfg), run, cfg).Stopped > 0; got != tt.wantStop {
+				t.Errorf("evaluateRun() stopped = %v, want %v", got, tt.wantStop)
+			}
+			if tt.metrics[0].Key != "val_loss" && run.Data.Metrics[0].Key != tt.metrics[0].Key {
+				t.Errorf("run metrics were renamed in place: %+v", run.Data.Metrics)
+			}
+		})
+	}
+}
+
+func TestScopeRuns(t *testing.T) {
+	parent := runningRun("parent")
+	child := runningRun("child")
+	child.Data.Tags = []types.RunTag{{Key: parentRunTag, Value: "parent"}}
+	runs := []types.Run{parent, child}
+
+	tests := []struct {
+		scope string
+		want  []string
+	}{
+		{config.RunScopeAll, []string{"parent", "child"}},
+		{config.RunScopeParents, []string{"parent"}},
+		{config.RunScopeChildren, []string{"child"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			var got []string
+			for _, run := range scopeRuns(runs, config.Config{RunScope: tt.scope}) {
+				got = append(got, run.Info.RunID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("scopeRuns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
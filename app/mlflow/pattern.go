@@ -0,0 +1,83 @@
+package mlflow
+
+import (
+	"context"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"path"
+	"time"
+)
+
+// ValidateRunNamePattern reports whether pattern is a valid glob, such as
+// sweep-2024-*.
+func ValidateRunNamePattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid run name pattern %q: %v", pattern, err)
+	}
+	return nil
+}
+
+// MonitorRunNamePattern polls the active runs whose name matches pattern,
+// across all experiments, until ctx is cancelled.
+func MonitorRunNamePattern(ctx context.Context, client MLflowClient, pattern string, config config.Config) {
+	ticker := time.NewTicker(time.Duration(config.PollInterval) * time.Second)
+	defer ticker.Stop()
+	wake, unsubscribe := subscribePollNow()
+	defer unsubscribe()
+
+	for {
+		PollRunNamePattern(client, pattern, config)
+		if !waitForTick(ctx, ticker, wake) {
+			return
+		}
+	}
+}
+
+// PollRunNamePattern checks every active run whose name matches pattern once.
+func PollRunNamePattern(client MLflowClient, pattern string, config config.Config) PollResult {
+	defer saveState()
+
+	runs, err := getActiveRunsByName(client, pattern, config)
+	if err != nil {
+		errorEvent(err).Msg("error fetching active runs")
+		emitError("", err, config)
+		return PollResult{Errors: 1}
+	}
+	syncTrackedRuns(runs, config)
+
+	if len(runs) == 0 {
+		log.Info().Str("pattern", pattern).Msg("no active runs match the run name pattern")
+		return PollResult{}
+	}
+
+	result := checkRuns(client, runs, config)
+	reportPoll(result, config)
+	return result
+}
+
+// getActiveRunsByName searches the active runs of every experiment and keeps
+// those whose run name matches the glob pattern. MLflow filters only support
+// SQL LIKE, so the glob is matched here instead.
+func getActiveRunsByName(client MLflowClient, pattern string, config config.Config) ([]types.Run, error) {
+	log.Debug().Str("pattern", pattern).Str("filter", config.ActiveRunsFilter).Msg("searching for active runs by name")
+
+	runs, err := searchAllRuns(client, types.SearchRunsRequest{
+		Filter:      config.ActiveRunsFilter,
+		RunViewType: "ACTIVE_ONLY",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch active runs: %w", err)
+	}
+
+	var matches []types.Run
+	for _, run := range runs {
+		if matched, _ := path.Match(pattern, run.Info.RunName); matched {
+			matches = append(matches, run)
+		}
+	}
+
+	log.Debug().Int("searched", len(runs)).Int("matched", len(matches)).Msg("found active runs by name")
+	return scopeRuns(matches, config), nil
+}
@@ -0,0 +1,130 @@
+package mlflow
+
+import (
+	"encoding/json"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/state"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestPollRunNamePatternFollowsPagesAndFiltersNames(t *testing.T) {
+	namedRun := func(runID string, name string) types.Run {
+		run := runningRun(runID, types.Metric{Key: "loss", Value: 3})
+		run.Info.RunName = name
+		return run
+	}
+	pages := map[string]types.GetRunsResponse{
+		"": {
+			Runs:          []types.Run{namedRun("run-1", "sweep-2024-a"), namedRun("run-2", "baseline")},
+			NextPageToken: "page-2",
+		},
+		"page-2": {Runs: []types.Run{namedRun("run-3", "sweep-2024-b")}},
+	}
+
+	var mu sync.Mutex
+	var stopped []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/mlflow/runs/search", func(w http.ResponseWriter, r *http.Request) {
+		var request types.SearchRunsRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode search body: %v", err)
+		}
+		writeJSON(t, w, pages[request.PageToken])
+	})
+	mux.HandleFunc("/api/2.0/mlflow/runs/get", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, types.GetRunResponse{Run: runningRun(r.URL.Query().Get("run_id"))})
+	})
+	mux.HandleFunc("/api/2.0/mlflow/runs/update", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode update body: %v", err)
+		}
+		mu.Lock()
+		stopped = append(stopped, body["run_id"])
+		mu.Unlock()
+		writeJSON(t, w, map[string]interface{}{})
+	})
+	mux.HandleFunc("/slack", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := config.Config{
+		MLflowTrackingURI: server.URL,
+		MetricThresholds:  map[string]config.Threshold{"loss": config.MaxThreshold(1)},
+		SlackWebhookURL:   server.URL + "/slack",
+		MessageChannels:   "SLACK",
+	}
+	result := PollRunNamePattern(newTestClient(t, cfg), "sweep-2024-*", cfg)
+
+	if want := (PollResult{Checked: 2, Stopped: 2}); result != want {
+		t.Errorf("PollRunNamePattern() = %+v, want %+v", result, want)
+	}
+	sort.Strings(stopped)
+	if len(stopped) != 2 || stopped[0] != "run-1" || stopped[1] != "run-3" {
+		t.Errorf("stopped runs = %v, want the two sweep runs", stopped)
+	}
+}
+
+func TestPollRunNamePatternHonoursStopLimit(t *testing.T) {
+	SetStateStore(state.NewMemoryStore())
+
+	var runs []types.Run
+	for _, runID := range []string{"run-1", "run-2", "run-3"} {
+		run := runningRun(runID, types.Metric{Key: "loss", Value: 3})
+		run.Info.RunName = "sweep-" + runID
+		runs = append(runs, run)
+	}
+
+	var mu sync.Mutex
+	var stopped []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/mlflow/runs/search", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, types.GetRunsResponse{Runs: runs})
+	})
+	mux.HandleFunc("/api/2.0/mlflow/runs/get", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, types.GetRunResponse{Run: runningRun(r.URL.Query().Get("run_id"))})
+	})
+	mux.HandleFunc("/api/2.0/mlflow/runs/update", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode update body: %v", err)
+		}
+		mu.Lock()
+		stopped = append(stopped, body["run_id"])
+		mu.Unlock()
+		writeJSON(t, w, map[string]interface{}{})
+	})
+	mux.HandleFunc("/slack", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := config.Config{
+		MLflowTrackingURI: server.URL,
+		MetricThresholds:  map[string]config.Threshold{"loss": config.MaxThreshold(1)},
+		SlackWebhookURL:   server.URL + "/slack",
+		MessageChannels:   "SLACK",
+		MaxStopsPerPoll:   1,
+	}
+	result := PollRunNamePattern(newTestClient(t, cfg), "sweep-*", cfg)
+
+	if want := (PollResult{Checked: 3, Stopped: 1}); result != want {
+		t.Errorf("PollRunNamePattern() = %+v, want %+v", result, want)
+	}
+	if len(stopped) != 1 {
+		t.Errorf("stopped runs = %v, want one with MAX_STOPS_PER_POLL=1", stopped)
+	}
+}
+
+func TestValidateRunNamePattern(t *testing.T) {
+	if err := ValidateRunNamePattern("sweep-2024-*"); err != nil {
+		t.Errorf("ValidateRunNamePattern() error = %v, want nil", err)
+	}
+	if err := ValidateRunNamePattern("sweep-[2024"); err == nil {
+		t.Error("ValidateRunNamePattern() should reject an unterminated class")
+	}
+}
@@ -0,0 +1,88 @@
+package mlflow
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ReportRuns fetches every run of experimentID, whatever its status, with
+// its latest metrics. Deleted runs are included since StopActionDelete moves
+// stopped runs to the trash.
+func ReportRuns(client MLflowClient, experimentID string) ([]types.Run, error) {
+	runs, err := searchAllRuns(client, types.SearchRunsRequest{
+		ExperimentIDs: []string{experimentID},
+		RunViewType:   "ALL",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch runs: %w", err)
+	}
+	return runs, nil
+}
+
+// WriteReport writes one CSV row per run with its latest value of every
+// metric that has a threshold, and whether and why it was stopped, as
+// recorded in the autostop.reason tag. Metrics a run never logged are left
+// empty.
+func WriteReport(w io.Writer, runs []types.Run, config config.Config) error {
+	metrics := reportMetrics(runs, config)
+
+	out := csv.NewWriter(w)
+	header := append([]string{"run_id", "run_name", "status"}, metrics...)
+	if err := out.Write(append(header, "stopped", "reason")); err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		values := make(map[string]float64, len(run.Data.Metrics))
+		for _, metric := range run.Data.Metrics {
+			values[metric.Key] = metric.Value
+		}
+
+		row := []string{run.Info.RunID, run.Info.RunName, run.Info.Status}
+		for _, key := range metrics {
+			value, ok := values[key]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, strconv.FormatFloat(value, 'g', -1, 64))
+		}
+
+		stopped := "no"
+		reason, ok := types.LookupTag(run.Data.Tags, stopReasonTag)
+		if ok {
+			stopped = "yes"
+		}
+		if err := out.Write(append(row, stopped, reason)); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// reportMetrics returns the sorted keys of the metrics logged by any of runs
+// that have a threshold.
+func reportMetrics(runs []types.Run, config config.Config) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, run := range runs {
+		for _, metric := range run.Data.Metrics {
+			if seen[metric.Key] {
+				continue
+			}
+			seen[metric.Key] = true
+			if _, ok := config.MetricThresholds.Lookup(metric.Key); ok {
+				keys = append(keys, metric.Key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
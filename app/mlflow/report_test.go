@@ -0,0 +1,36 @@
+package mlflow
+
+import (
+	"bytes"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"testing"
+)
+
+func TestWriteReportListsThresholdMetricsAndStopReason(t *testing.T) {
+	cfg := config.Config{
+		MetricThresholds: config.Thresholds{"loss": config.MaxThreshold(5), "val_*": config.MaxThreshold(1)},
+	}
+	stopped := runningRun("run-1",
+		types.Metric{Key: "loss", Value: 6.5},
+		types.Metric{Key: "lr", Value: 0.01},
+	)
+	stopped.Info.Status = "FAILED"
+	stopped.Data.Tags = []types.RunTag{{Key: stopReasonTag, Value: "loss 6.5 above 5"}}
+	runs := []types.Run{
+		stopped,
+		runningRun("run-2", types.Metric{Key: "val_loss", Value: 0.25}),
+	}
+
+	var out bytes.Buffer
+	if err := WriteReport(&out, runs, cfg); err != nil {
+		t.Fatalf("WriteReport() error = %v", err)
+	}
+
+	want := "run_id,run_name,status,loss,val_loss,stopped,reason\n" +
+		"run-1,,FAILED,6.5,,yes,loss 6.5 above 5\n" +
+		"run-2,,RUNNING,,0.25,no,\n"
+	if out.String() != want {
+		t.Errorf("WriteReport() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
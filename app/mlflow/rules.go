@@ -0,0 +1,473 @@
+package mlflow
+
+import (
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/expression"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// violation describes a metric that caused a run to be stopped or warned
+// about. Threshold is NaN when the violation is not a threshold crossing.
+// Critical marks violations such as a diverged (NaN/Inf) metric that warrant
+// paging; Warning marks a warning threshold crossing that doesn't stop the
+// run.
+type violation struct {
+	RunID     string
+	RunName   string
+	Metric    string
+	Value     float64
+	Step      int
+	Threshold float64
+	Reason    string
+	Critical  bool
+	Warning   bool
+}
+
+// severity returns the notification severity of v.
+func (v violation) severity() types.Severity {
+	switch {
+	case v.Critical:
+		return types.SeverityCritical
+	case v.Warning:
+		return types.SeverityWarning
+	}
+	return types.SeverityError
+}
+
+// fields returns the structured details attached to the notification.
+func (v violation) fields() []types.NotificationField {
+	threshold := "n/a"
+	if !math.IsNaN(v.Threshold) {
+		threshold = fmt.Sprintf("%.4f", v.Threshold)
+	}
+
+	return []types.NotificationField{
+		{Key: types.FieldRunID, Title: "Run ID", Value: v.RunID},
+		{Key: types.FieldMetric, Title: "Metric", Value: v.Metric},
+		{Key: types.FieldValue, Title: "Value", Value: fmt.Sprintf("%.4f", v.Value)},
+		{Key: types.FieldThreshold, Title: "Threshold", Value: threshold},
+		{Key: types.FieldSeverity, Title: "Severity", Value: string(v.severity())},
+	}
+}
+
+// metricViolation reports whether metric should stop the run and, if so,
+// describes why. NaN and Inf values are caught before the threshold
+// comparison since they never compare greater.
+func metricViolation(run types.Run, metric types.Metric, config config.Config) (violation, bool) {
+	v := newViolation(run.Info, metric)
+
+	if config.StopOnNaN && (math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0)) {
+		v.Critical = true
+		v.Reason = fmt.Sprintf("Metric %s went %v", metric.Key, metric.Value)
+		return v, true
+	}
+
+	threshold, exists := config.MetricThresholds.Lookup(metric.Key)
+	if override, ok := tagThreshold(run, metric.Key); ok {
+		threshold, exists = override, true
+	}
+	if !exists || metric.Step < threshold.WarmupSteps {
+		return v, false
+	}
+
+	v, violated := thresholdViolation(v, resolveThreshold(run, metric.Key, threshold), config.ThresholdEpsilon, "threshold")
+	v.Critical = threshold.Critical()
+	return v, violated
+}
+
+// patienceKey prefixes the ViolationCounts entries that count consecutive
+// threshold violations towards a Patience.
+const patienceKey = "patience:"
+
+// patienceExhausted reports whether a violation v of its threshold, seen on
+// the current poll when violated is set, should stop the run. With a
+// Patience the threshold has to be violated on that many consecutive polls;
+// a poll within bounds starts the count over. NaN and Inf values are not
+// threshold crossings and always stop.
+func patienceExhausted(run types.Run, v violation, violated bool, config config.Config) bool {
+	threshold, _ := config.MetricThresholds.Lookup(v.Metric)
+	if _, tagged := tagThreshold(run, v.Metric); tagged || threshold.Patience <= 1 || (violated && math.IsNaN(v.Threshold)) {
+		return violated
+	}
+
+	key := patienceKey + v.Metric
+	if !violated {
+		resetViolations(run.Info.RunID, key)
+		return false
+	}
+	count := countViolation(run.Info.RunID, key)
+	if count < threshold.Patience {
+		log.Info().Str("run_id", run.Info.RunID).Str("metric", v.Metric).Int("count", count).
+			Int("patience", threshold.Patience).Msg("metric violates its threshold, waiting for patience to run out")
+		return false
+	}
+	return true
+}
+
+// thresholdTagPrefix starts the run tags that override METRIC_THRESHOLDS for
+// a single run, e.g. autostop.threshold.loss=3.0.
+const thresholdTagPrefix = "autostop.threshold."
+
+// tagThreshold returns the upper bound set for metric by a threshold tag on
+// run. A tag that is not a finite number is ignored with a warning, leaving
+// the configured threshold in place.
+func tagThreshold(run types.Run, metric string) (config.Threshold, bool) {
+	raw, ok := types.LookupTag(run.Data.Tags, thresholdTagPrefix+metric)
+	if !ok {
+		return config.Threshold{}, false
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		log.Warn().Str("run_id", run.Info.RunID).Str("metric", metric).Str("value", raw).
+			Msg("threshold tag is not a finite number, using the configured threshold")
+		return config.Threshold{}, false
+	}
+	return config.MaxThreshold(value), true
+}
+
+// stepViolation reports whether metric was logged at a step beyond its
+// StepLimits entry, catching runs that keep training past their schedule.
+// Value and Threshold of the violation hold the step and the limit.
+func stepViolation(run types.RunInfo, metric types.Metric, config config.Config) (violation, bool) {
+	v := newViolation(run, metric)
+
+	limit, exists := config.StepLimits[metric.Key]
+	if !exists || metric.Step <= limit {
+		return v, false
+	}
+
+	v.Value = float64(metric.Step)
+	v.Threshold = float64(limit)
+	v.Reason = fmt.Sprintf("Metric %s reached step %d, past the limit of %d steps", metric.Key, metric.Step, limit)
+	return v, true
+}
+
+// missingMetricViolation reports the first RequiredMetrics entry that run has
+// not logged within MissingMetricTimeoutSeconds: either never, counting from
+// the run's start, or not since its last update. Value and Threshold of the
+// violation are unset.
+func missingMetricViolation(run types.Run, config config.Config) (violation, bool) {
+	timeout := time.Duration(config.MissingMetricTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		return violation{}, false
+	}
+
+	for _, key := range config.RequiredMetrics {
+		v := newViolation(run.Info, types.Metric{Key: key, Value: math.NaN()})
+		v.Warning = !config.StopOnMissingMetric
+
+		metric, logged := findMetric(run.Data.Metrics, key)
+		if logged {
+			if since := time.Since(observeMetric(run.Info.RunID, metric)); since > timeout {
+				v.Reason = fmt.Sprintf("Metric %s has not been updated for %s", key, since.Round(time.Second))
+				return v, true
+			}
+			continue
+		}
+
+		if run.Info.StartTime == 0 {
+			continue
+		}
+		if since := time.Since(time.UnixMilli(run.Info.StartTime)); since > timeout {
+			v.Reason = fmt.Sprintf("Metric %s has not been logged %s after the run started", key, since.Round(time.Second))
+			return v, true
+		}
+	}
+	return violation{}, false
+}
+
+// metricAge returns how long ago metric was logged and whether that is past
+// MaxMetricAgeSeconds. Metrics without a timestamp are never stale.
+func metricAge(metric types.Metric, config config.Config) (time.Duration, bool) {
+	if config.MaxMetricAgeSeconds <= 0 || metric.Timestamp == 0 {
+		return 0, false
+	}
+
+	age := time.Since(time.UnixMilli(metric.Timestamp))
+	return age, age > time.Duration(config.MaxMetricAgeSeconds)*time.Second
+}
+
+// stalledViolation reports run as stalled when it has metrics and all of them
+// are past MaxMetricAgeSeconds. Value holds the age in seconds of the most
+// recent metric; the violation is always a warning.
+func stalledViolation(run types.Run, config config.Config) (violation, bool) {
+	if len(run.Data.Metrics) == 0 {
+		return violation{}, false
+	}
+
+	newest := time.Duration(math.MaxInt64)
+	for _, metric := range run.Data.Metrics {
+		age, stale := metricAge(metric, config)
+		if !stale {
+			return violation{}, false
+		}
+		newest = min(newest, age)
+	}
+
+	v := newViolation(run.Info, types.Metric{Value: newest.Seconds()})
+	v.Warning = true
+	v.Reason = fmt.Sprintf("Run appears stalled: no metric logged for %s", newest.Round(time.Second))
+	return v, true
+}
+
+func findMetric(metrics []types.Metric, key string) (types.Metric, bool) {
+	for _, metric := range metrics {
+		if metric.Key == key {
+			return metric, true
+		}
+	}
+	return types.Metric{}, false
+}
+
+// expressions caches the parsed ComputedMetrics expressions by source.
+var expressions sync.Map
+
+// computedMetrics evaluates the ComputedMetrics entries over metrics, in name
+// order. Each result takes the latest step and timestamp of the metrics it
+// was computed from. An entry referencing a metric the run has not logged yet
+// is skipped.
+func computedMetrics(runID string, metrics []types.Metric, config config.Config) []types.Metric {
+	if len(config.ComputedMetrics) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(config.ComputedMetrics))
+	for name := range config.ComputedMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		values[metric.Key] = metric.Value
+	}
+
+	computed := make([]types.Metric, 0, len(names))
+	for _, name := range names {
+		expr, err := parseExpression(config.ComputedMetrics[name])
+		if err != nil {
+			log.Error().Err(err).Str("run_id", runID).Str("metric", name).Msg("failed to parse computed metric")
+			continue
+		}
+
+		value, err := expr.Eval(values)
+		if err != nil {
+			log.Debug().Err(err).Str("run_id", runID).Str("metric", name).Msg("skipping computed metric")
+			continue
+		}
+
+		metric := types.Metric{Key: name, Value: value}
+		for _, key := range expr.Keys() {
+			source, _ := findMetric(metrics, key)
+			metric.Step = max(metric.Step, source.Step)
+			metric.Timestamp = max(metric.Timestamp, source.Timestamp)
+		}
+		computed = append(computed, metric)
+	}
+	return computed
+}
+
+// compositeViolation reports the first CompositeScores entry, in name order,
+// whose score over metrics exceeds its threshold. A score is skipped with a
+// warning while any of its metrics is missing, rather than counting it as 0.
+func compositeViolation(run types.RunInfo, metrics []types.Metric, config config.Config) (violation, bool) {
+	names := make([]string, 0, len(config.CompositeScores))
+	for name := range config.CompositeScores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		rule := config.CompositeScores[name]
+		score := types.Metric{Key: name, Value: rule.Bias}
+		complete := true
+		for key, weight := range rule.Weights {
+			metric, logged := findMetric(metrics, key)
+			if !logged {
+				log.Warn().Str("run_id", run.RunID).Str("score", name).Str("metric", key).
+					Msg("composite score metric is not logged, skipping score")
+				complete = false
+				break
+			}
+			score.Value += weight * metric.Value
+			score.Step = max(score.Step, metric.Step)
+		}
+		if !complete || score.Value <= rule.Threshold+config.ThresholdEpsilon {
+			continue
+		}
+
+		v := newViolation(run, score)
+		v.Threshold = rule.Threshold
+		v.Reason = fmt.Sprintf("Composite score %s = %.4f exceeded threshold %.4f", name, score.Value, rule.Threshold)
+		return v, true
+	}
+	return violation{}, false
+}
+
+func parseExpression(source string) (*expression.Expression, error) {
+	if cached, ok := expressions.Load(source); ok {
+		return cached.(*expression.Expression), nil
+	}
+
+	expr, err := expression.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	expressions.Store(source, expr)
+	return expr, nil
+}
+
+// metricDirection reports whether metric improves upwards, judged from its
+// threshold: only a min bound means higher-is-better, only a max bound
+// lower-is-better. ok is false for banded or unthresholded metrics.
+func metricDirection(metric string, config config.Config) (higherIsBetter bool, ok bool) {
+	threshold, found := config.MetricThresholds.Lookup(metric)
+	if !found {
+		return false, false
+	}
+
+	hasMin := threshold.Min != nil || threshold.MinParam != ""
+	hasMax := threshold.Max != nil || threshold.MaxParam != ""
+	if hasMin == hasMax {
+		return false, false
+	}
+	return hasMin, true
+}
+
+// regressionViolation reports whether metric fell back from the best value
+// the run reached by more than MaxRegressionPct. The first time a metric is
+// seen its best value is seeded from the metric history, so a peak reached
+// before the monitor started still counts.
+func regressionViolation(client MLflowClient, run types.RunInfo, metric types.Metric, config config.Config) (violation, bool) {
+	v := newViolation(run, metric)
+	if config.MaxRegressionPct <= 0 {
+		return v, false
+	}
+	higherIsBetter, ok := metricDirection(metric.Key, config)
+	if !ok {
+		return v, false
+	}
+
+	if _, seen := bestValue(run.RunID, metric.Key); !seen {
+		seedBestValue(client, run.RunID, metric.Key, higherIsBetter)
+	}
+	best := recordBestValue(run.RunID, metric.Key, metric.Value, higherIsBetter)
+
+	margin := math.Abs(best) * config.MaxRegressionPct / 100
+	if higherIsBetter {
+		v.Threshold = best - margin
+		if metric.Value >= v.Threshold {
+			return v, false
+		}
+	} else {
+		v.Threshold = best + margin
+		if metric.Value <= v.Threshold {
+			return v, false
+		}
+	}
+
+	v.Reason = fmt.Sprintf("Metric %s = %.4f regressed more than %.1f%% from its best value %.4f",
+		metric.Key, metric.Value, config.MaxRegressionPct, best)
+	return v, true
+}
+
+// seedBestValue records the best finite value in the history of metric. On
+// failure the best value is tracked from the latest value onwards.
+func seedBestValue(client MLflowClient, runID string, metric string, higherIsBetter bool) {
+	history, err := client.GetMetricHistory(runID, metric)
+	if err != nil {
+		errorEvent(err).Str("run_id", runID).Str("metric", metric).
+			Msg("failed to fetch metric history, tracking the best value from the latest one")
+		return
+	}
+
+	for _, point := range history.Metrics {
+		if math.IsNaN(point.Value) || math.IsInf(point.Value, 0) {
+			continue
+		}
+		recordBestValue(runID, metric, point.Value, higherIsBetter)
+	}
+}
+
+// warningViolation reports whether metric crossed its warning threshold.
+func warningViolation(run types.Run, metric types.Metric, config config.Config) (violation, bool) {
+	v := newViolation(run.Info, metric)
+	v.Warning = true
+
+	threshold, exists := config.WarnThresholds.Lookup(metric.Key)
+	if !exists {
+		return v, false
+	}
+
+	return thresholdViolation(v, resolveThreshold(run, metric.Key, threshold), config.ThresholdEpsilon, "warning threshold")
+}
+
+// resolveThreshold fills the bounds of threshold that name a run param with
+// the param's value. A bound whose param is missing or not a number is left
+// unset, so only the other bound, if any, is checked.
+func resolveThreshold(run types.Run, metric string, threshold config.Threshold) config.Threshold {
+	if threshold.MinParam != "" {
+		threshold.Min = paramBound(run, metric, threshold.MinParam)
+	}
+	if threshold.MaxParam != "" {
+		threshold.Max = paramBound(run, metric, threshold.MaxParam)
+	}
+	return threshold
+}
+
+func paramBound(run types.Run, metric string, param string) *float64 {
+	raw, ok := types.LookupParam(run.Data.Params, param)
+	if !ok {
+		log.Warn().Str("run_id", run.Info.RunID).Str("metric", metric).Str("param", param).
+			Msg("threshold param is not set on the run, skipping bound")
+		return nil
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		log.Warn().Str("run_id", run.Info.RunID).Str("metric", metric).Str("param", param).Str("value", raw).
+			Msg("threshold param is not a finite number, skipping bound")
+		return nil
+	}
+	return &value
+}
+
+func newViolation(run types.RunInfo, metric types.Metric) violation {
+	return violation{
+		RunID:     run.RunID,
+		RunName:   run.RunName,
+		Metric:    metric.Key,
+		Value:     metric.Value,
+		Step:      metric.Step,
+		Threshold: math.NaN(),
+	}
+}
+
+// thresholdViolation checks v.Value against the bounds of threshold widened
+// by epsilon, naming the violated bound with label in the reason.
+func thresholdViolation(v violation, threshold config.Threshold, epsilon float64, label string) (violation, bool) {
+	if threshold.Max != nil && v.Value > *threshold.Max+epsilon {
+		v.Threshold = *threshold.Max
+		v.Reason = fmt.Sprintf("Metric %s = %.4f exceeded %s %.4f",
+			v.Metric, v.Value, label, *threshold.Max)
+		return v, true
+	}
+
+	if threshold.Min != nil && v.Value < *threshold.Min-epsilon {
+		v.Threshold = *threshold.Min
+		v.Reason = fmt.Sprintf("Metric %s = %.4f fell below minimum %s %.4f",
+			v.Metric, v.Value, label, *threshold.Min)
+		return v, true
+	}
+
+	return v, false
+}
@@ -0,0 +1,144 @@
+package mlflow
+
+import (
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/state"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// runState holds per-run counters and alert history. It lives in memory
+// until main installs a persistent store with SetStateStore.
+var runState state.Store = state.NewMemoryStore()
+
+// SetStateStore replaces the store used to remember run state between polls.
+func SetStateStore(store state.Store) {
+	runState = store
+}
+
+// saveState persists the store after a poll. A failed write is logged rather
+// than aborting monitoring; the in-memory state is still intact.
+func saveState() {
+	if err := runState.Save(); err != nil {
+		log.Error().Err(err).Msg("failed to save state")
+	}
+}
+
+// recordViolation counts a threshold violation of metric on runID.
+func recordViolation(runID string, metric string) {
+	runState.Update(runID, func(s *state.RunState) {
+		s.ViolationCounts[metric]++
+	})
+}
+
+// countViolation increments the violation count under key for runID and
+// returns the new count.
+func countViolation(runID string, key string) int {
+	var count int
+	runState.Update(runID, func(s *state.RunState) {
+		s.ViolationCounts[key]++
+		count = s.ViolationCounts[key]
+	})
+	return count
+}
+
+// resetViolations clears the violation count under key for runID.
+func resetViolations(runID string, key string) {
+	if _, counted := runState.Get(runID).ViolationCounts[key]; !counted {
+		return
+	}
+	runState.Update(runID, func(s *state.RunState) {
+		delete(s.ViolationCounts, key)
+	})
+}
+
+// markWarning records a warning for metric and reports whether it is new, so
+// a warning is sent once per crossing rather than every poll.
+func markWarning(runID string, metric string) bool {
+	isNew := false
+	runState.Update(runID, func(s *state.RunState) {
+		if _, warned := s.LastAlerts[metric]; warned {
+			return
+		}
+		s.LastAlerts[metric] = time.Now()
+		s.ViolationCounts[metric]++
+		isNew = true
+	})
+	return isNew
+}
+
+// clearWarning forgets a warning once the metric is back within bounds so
+// that a later crossing warns again.
+func clearWarning(runID string, metric string) {
+	if _, warned := runState.Get(runID).LastAlerts[metric]; !warned {
+		return
+	}
+	runState.Update(runID, func(s *state.RunState) {
+		delete(s.LastAlerts, metric)
+	})
+}
+
+// observeMetric records the latest value of metric on runID and returns when
+// its timestamp last changed, as seen by this monitor.
+func observeMetric(runID string, metric types.Metric) time.Time {
+	seen, ok := runState.Get(runID).MetricSeen[metric.Key]
+	if ok && seen.Timestamp == metric.Timestamp {
+		return seen.SeenAt
+	}
+
+	seen = state.MetricSeen{Timestamp: metric.Timestamp, SeenAt: time.Now()}
+	runState.Update(runID, func(s *state.RunState) {
+		s.MetricSeen[metric.Key] = seen
+	})
+	return seen.SeenAt
+}
+
+// bestValue returns the best value of metric recorded for runID.
+func bestValue(runID string, metric string) (float64, bool) {
+	best, ok := runState.Get(runID).BestValues[metric]
+	return best, ok
+}
+
+// recordBestValue keeps value as the best value of metric on runID when it
+// improves on the recorded one, and returns the best value.
+func recordBestValue(runID string, metric string, value float64, higherIsBetter bool) float64 {
+	var best float64
+	runState.Update(runID, func(s *state.RunState) {
+		current, ok := s.BestValues[metric]
+		if !ok || (higherIsBetter && value > current) || (!higherIsBetter && value < current) {
+			s.BestValues[metric] = value
+		}
+		best = s.BestValues[metric]
+	})
+	return best
+}
+
+// requestStop records that a soft stop of runID was requested now.
+func requestStop(runID string) {
+	runState.Update(runID, func(s *state.RunState) {
+		s.StopRequestedAt = time.Now()
+	})
+}
+
+// markStopped records that runID was stopped now.
+func markStopped(runID string) {
+	runState.Update(runID, func(s *state.RunState) {
+		s.StoppedAt = time.Now()
+	})
+}
+
+// recentlyStopped reports whether runID was stopped within
+// RecentlyStoppedTTLSeconds, in this session or, with a persistent store, a
+// previous one.
+func recentlyStopped(runID string, config config.Config) bool {
+	stoppedAt := runState.Get(runID).StoppedAt
+	ttl := time.Duration(config.RecentlyStoppedTTLSeconds) * time.Second
+	return !stoppedAt.IsZero() && time.Since(stoppedAt) < ttl
+}
+
+// stopRequestedAt returns when a soft stop of runID was requested, or the
+// zero time if none was.
+func stopRequestedAt(runID string) time.Time {
+	return runState.Get(runID).StopRequestedAt
+}
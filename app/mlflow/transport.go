@@ -0,0 +1,96 @@
+package mlflow
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// newHTTPClient builds the *http.Client shared by every MLflow request,
+// applying the configured proxy and TLS settings to its transport. With
+// OAuthTokenURL set, requests carry a client-credentials access token.
+func newHTTPClient(config config.Config) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := httpclient.NewTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	client := &http.Client{Transport: httpclient.WithUserAgent(transport, config)}
+	if config.OAuthTokenURL == "" {
+		return client, nil
+	}
+
+	// Token requests go through the same proxy and TLS settings as MLflow
+	// requests. The returned client caches the token and fetches a new one
+	// shortly before it expires.
+	credentials := clientcredentials.Config{
+		ClientID:     config.OAuthClientID,
+		ClientSecret: config.OAuthClientSecret,
+		TokenURL:     config.OAuthTokenURL,
+		Scopes:       oauthScopes(config.OAuthScopes),
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	return credentials.Client(ctx), nil
+}
+
+// oauthScopes splits a comma or space separated scope list.
+func oauthScopes(scopes string) []string {
+	return strings.FieldsFunc(scopes, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// newTLSConfig returns nil when no TLS settings are configured so the
+// transport keeps Go's defaults.
+func newTLSConfig(config config.Config) (*tls.Config, error) {
+	if config.MLflowClientCertFile == "" && config.MLflowCACertFile == "" && !config.MLflowInsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.MLflowClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.MLflowClientCertFile, config.MLflowClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MLflow client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.MLflowCACertFile != "" {
+		caCert, err := os.ReadFile(config.MLflowCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MLflow CA certificate: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid certificates found in %s", config.MLflowCACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.MLflowInsecureSkipVerify {
+		log.Warn().Msg("MLFLOW_INSECURE_SKIP_VERIFY is enabled: MLflow TLS certificates are NOT verified, never use this in production")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,115 @@
+package mlflow
+
+import (
+	"encoding/pem"
+	"github.com/gidra39/mlflow-autostop/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewHTTPClientTrustsConfiguredCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  config.Config
+		wantErr bool
+	}{
+		{"default roots reject the test CA", config.Config{}, true},
+		{"configured CA is trusted", config.Config{MLflowCACertFile: caFile}, false},
+		{"insecure skip verify", config.Config{MLflowInsecureSkipVerify: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newHTTPClient(tt.config)
+			if err != nil {
+				t.Fatalf("newHTTPClient() error = %v", err)
+			}
+
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GET error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigRejectsInvalidCA(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newTLSConfig(config.Config{MLflowCACertFile: caFile}); err == nil {
+		t.Fatal("expected an error for a CA file without certificates")
+	}
+}
+
+func TestNewClientUsesOAuthClientCredentials(t *testing.T) {
+	tokenRequests := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Form.Get("grant_type"); got != "client_credentials" {
+			t.Errorf("grant_type = %q, want client_credentials", got)
+		}
+		if got := r.Form.Get("scope"); got != "read write" {
+			t.Errorf("scope = %q, want %q", got, "read write")
+		}
+		writeJSON(t, w, map[string]interface{}{
+			"access_token": "oauth-token",
+			"token_type":   "bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer tokenServer.Close()
+
+	var authHeaders []string
+	mlflowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		writeJSON(t, w, map[string]interface{}{})
+	}))
+	defer mlflowServer.Close()
+
+	client := newTestClient(t, config.Config{
+		MLflowTrackingURI:   mlflowServer.URL,
+		MLflowTrackingToken: "static-token",
+		OAuthTokenURL:       tokenServer.URL,
+		OAuthClientID:       "client",
+		OAuthClientSecret:   "secret",
+		OAuthScopes:         "read,write",
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetRun("run-1"); err != nil {
+			t.Fatalf("GetRun() error = %v", err)
+		}
+	}
+
+	for _, header := range authHeaders {
+		if header != "Bearer oauth-token" {
+			t.Errorf("Authorization = %q, want the OAuth token", header)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("token endpoint called %d times, want 1", tokenRequests)
+	}
+}
@@ -0,0 +1,392 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/audit"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/events"
+	"github.com/gidra39/mlflow-autostop/messaging"
+	"github.com/gidra39/mlflow-autostop/mlflow"
+	"github.com/gidra39/mlflow-autostop/state"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Monitor watches MLflow runs and stops the ones that break the configured
+// rules. It is the entrypoint for embedding mlflow-autostop in another Go
+// program: build the config however suits the host, set the target fields
+// and call Run. The mlflow-autostop binary is a thin wrapper filling these
+// in from its flags.
+//
+// When the config lists several tracking servers, each is monitored
+// independently with its own client, sharing the rules, state and
+// notification channels.
+type Monitor struct {
+	// Client talks to MLflow. When nil, one is created per tracking server
+	// from the config on first use, and Client is set to the first; set it
+	// to inject another implementation, which then replaces the configured
+	// servers.
+	Client mlflow.MLflowClient
+
+	// RunID, ExperimentID, ExperimentName and RunNamePattern select the runs
+	// to monitor. RunNamePattern cannot be combined with the others, nor
+	// ExperimentID with ExperimentName. With none set every active run on
+	// the server is monitored.
+	RunID          string
+	ExperimentID   string
+	ExperimentName string
+	RunNamePattern string
+
+	config   config.Config
+	servers  []server
+	prepared bool
+	auditLog *audit.Log
+}
+
+// server is one tracking server to monitor. experimentID is the resolved
+// target experiment, since the ID of a named experiment differs by server.
+type server struct {
+	config       config.Config
+	client       mlflow.MLflowClient
+	experimentID string
+}
+
+// New returns a Monitor for config. Nothing is contacted until the first
+// call to Run, CheckOnce, CheckRunOnce, Validate or ListRuns.
+func New(config config.Config) *Monitor {
+	return &Monitor{config: config}
+}
+
+// Config returns the configuration the monitor was created with.
+func (m *Monitor) Config() config.Config {
+	return m.config
+}
+
+// Run monitors the selected runs until ctx is cancelled or, when RunID is
+// set, until that run finishes or is stopped. It returns the accumulated
+// result of the polls.
+func (m *Monitor) Run(ctx context.Context) (mlflow.PollResult, error) {
+	if err := m.prepare(); err != nil {
+		return mlflow.PollResult{}, err
+	}
+
+	target := m.target()
+	events.Emit(events.Event{Type: events.TypeMonitorStarted, Target: target}, m.config)
+	if m.config.NotifyOnStartup {
+		m.notify(fmt.Sprintf("▶️ MLflow autostop started monitoring %s\nThresholds: %s",
+			target, formatThresholds(m.config.MetricThresholds)))
+	}
+
+	result := m.monitor(ctx)
+
+	events.Emit(events.Event{Type: events.TypeMonitorStopped, Target: target}, m.config)
+	if m.config.NotifyOnShutdown {
+		m.notify(fmt.Sprintf("⏹️ MLflow autostop stopped monitoring %s", target))
+	}
+	return result, nil
+}
+
+// CheckOnce performs a single pass over the selected runs.
+func (m *Monitor) CheckOnce(ctx context.Context) (mlflow.PollResult, error) {
+	if err := m.prepare(); err != nil {
+		return mlflow.PollResult{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return mlflow.PollResult{}, err
+	}
+
+	var result mlflow.PollResult
+	for _, s := range m.servers {
+		result.Add(m.checkServer(s))
+	}
+	return result, nil
+}
+
+func (m *Monitor) checkServer(s server) mlflow.PollResult {
+	if m.RunID != "" {
+		result, _ := mlflow.PollSpecificRun(s.client, m.RunID, s.config)
+		return result
+	} else if m.RunNamePattern != "" {
+		return mlflow.PollRunNamePattern(s.client, m.RunNamePattern, s.config)
+	} else if s.experimentID != "" {
+		return mlflow.PollExperiment(s.client, s.experimentID, s.config)
+	}
+	return mlflow.PollAllActiveRuns(s.client, s.config)
+}
+
+// CheckRunOnce checks runID once, regardless of the selected target, and
+// stops it if it breaks the rules. It needs a single tracking server, as a
+// run ID does not say which server the run lives on.
+func (m *Monitor) CheckRunOnce(ctx context.Context, runID string) (mlflow.PollResult, error) {
+	if err := m.prepare(); err != nil {
+		return mlflow.PollResult{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return mlflow.PollResult{}, err
+	}
+	if len(m.servers) > 1 {
+		return mlflow.PollResult{}, errors.New("checking a run ID needs a single tracking server")
+	}
+
+	s := m.servers[0]
+	result, _ := mlflow.PollSpecificRun(s.client, runID, s.config)
+	return result, nil
+}
+
+// Validate checks the configuration without monitoring anything: the
+// notification channels and target are validated, and the MLflow tracking
+// server is contacted. With notify set, a test message is also sent through
+// every channel.
+func (m *Monitor) Validate(notify bool) error {
+	if err := m.prepare(); err != nil {
+		return err
+	}
+
+	for _, s := range m.servers {
+		if err := s.client.Ping(); err != nil {
+			return err
+		}
+		log.Info().Str("uri", s.config.DisplayTrackingURI()).Msg("MLflow tracking server is reachable")
+	}
+
+	if notify {
+		if err := messaging.CheckChannels(m.config); err != nil {
+			return fmt.Errorf("failed to send test notification: %w", err)
+		}
+		log.Info().Str("channels", m.config.MessageChannels).Msg("sent test notification")
+	}
+	return nil
+}
+
+// ListRuns returns the runs the monitor would check, with their latest
+// metrics, without evaluating them.
+func (m *Monitor) ListRuns() ([]types.Run, error) {
+	if err := m.prepare(); err != nil {
+		return nil, err
+	}
+
+	var all []types.Run
+	for _, s := range m.servers {
+		runs, err := mlflow.ListRuns(s.client, m.RunID, s.experimentID, m.RunNamePattern, s.config)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, runs...)
+	}
+	return all, nil
+}
+
+// Report returns every run of the monitored experiment, across all servers,
+// for the metrics-only report. Nothing is evaluated or stopped.
+func (m *Monitor) Report() ([]types.Run, error) {
+	if err := m.prepare(); err != nil {
+		return nil, err
+	}
+
+	var all []types.Run
+	for _, s := range m.servers {
+		if s.experimentID == "" {
+			return nil, errors.New("the report needs an experiment ID or name")
+		}
+		runs, err := mlflow.ReportRuns(s.client, s.experimentID)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, runs...)
+	}
+	return all, nil
+}
+
+// Close flushes the notification channels and the audit log. The monitor
+// must not be used afterwards.
+func (m *Monitor) Close() error {
+	var errs []error
+	if err := messaging.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if m.auditLog != nil {
+		if err := m.auditLog.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close audit log: %v", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// prepare validates the target and sets up the client, state store, audit
+// log and notification channels on first use.
+func (m *Monitor) prepare() error {
+	if m.prepared {
+		return nil
+	}
+
+	if err := messaging.ValidateChannels(m.config); err != nil {
+		return fmt.Errorf("invalid notification channel configuration: %w", err)
+	}
+
+	if m.Client != nil {
+		m.servers = []server{{config: m.config, client: m.Client}}
+	} else {
+		for _, cfg := range m.config.Servers() {
+			log.Info().Str("uri", cfg.DisplayTrackingURI()).Msg("using MLflow tracking URI")
+			client, err := mlflow.NewClient(cfg)
+			if err != nil {
+				return fmt.Errorf("failed to create MLflow client for %s: %w", cfg.DisplayTrackingURI(), err)
+			}
+			m.servers = append(m.servers, server{config: cfg, client: client})
+		}
+		m.Client = m.servers[0].client
+	}
+
+	if err := m.resolveTarget(); err != nil {
+		return err
+	}
+
+	store, err := state.New(m.config.StatePath)
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	mlflow.SetStateStore(store)
+
+	if m.config.AuditLogPath != "" {
+		auditLog, err := audit.Open(m.config.AuditLogPath)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		mlflow.SetAuditLog(auditLog)
+		m.auditLog = auditLog
+	}
+
+	if err := messaging.Open(m.config); err != nil {
+		return fmt.Errorf("failed to open notification channels: %w", err)
+	}
+
+	m.prepared = true
+	return nil
+}
+
+// resolveTarget rejects conflicting targets and resolves ExperimentName to
+// its ID on every server.
+func (m *Monitor) resolveTarget() error {
+	if m.RunID != "" && len(m.servers) > 1 {
+		return errors.New("a run ID cannot be monitored across several tracking servers")
+	}
+
+	for i := range m.servers {
+		m.servers[i].experimentID = m.ExperimentID
+	}
+
+	if m.ExperimentName != "" {
+		if m.ExperimentID != "" {
+			return errors.New("an experiment ID and an experiment name cannot be used together")
+		}
+
+		for i, s := range m.servers {
+			id, err := mlflow.GetExperimentIDByName(s.client, m.ExperimentName)
+			if err != nil {
+				return fmt.Errorf("failed to resolve experiment %q on %s: %w", m.ExperimentName, s.config.DisplayTrackingURI(), err)
+			}
+			log.Info().Str("uri", s.config.DisplayTrackingURI()).Str("experiment_name", m.ExperimentName).
+				Str("experiment_id", id).Msg("resolved experiment")
+			m.servers[i].experimentID = id
+		}
+		if len(m.servers) == 1 {
+			m.ExperimentID = m.servers[0].experimentID
+		}
+	}
+
+	if m.RunNamePattern != "" {
+		if m.RunID != "" || m.ExperimentID != "" {
+			return errors.New("a run name pattern cannot be combined with a run ID or an experiment")
+		}
+		if err := mlflow.ValidateRunNamePattern(m.RunNamePattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// monitor runs the selected monitoring mode on every server until it
+// finishes. Only the specific-run mode ever returns before ctx is cancelled.
+func (m *Monitor) monitor(ctx context.Context) mlflow.PollResult {
+	if len(m.servers) == 1 {
+		return m.monitorServer(ctx, m.servers[0])
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result mlflow.PollResult
+	)
+	for _, s := range m.servers {
+		wg.Add(1)
+		go func(s server) {
+			defer wg.Done()
+			r := m.monitorServer(ctx, s)
+			mu.Lock()
+			result.Add(r)
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+	return result
+}
+
+func (m *Monitor) monitorServer(ctx context.Context, s server) mlflow.PollResult {
+	uri := s.config.DisplayTrackingURI()
+	if m.RunID != "" {
+		log.Info().Str("uri", uri).Str("run_id", m.RunID).Msg("monitoring specific run")
+		return mlflow.MonitorSpecificRun(ctx, s.client, m.RunID, s.config)
+	} else if m.RunNamePattern != "" {
+		log.Info().Str("uri", uri).Str("pattern", m.RunNamePattern).Msg("monitoring active runs matching name pattern")
+		mlflow.MonitorRunNamePattern(ctx, s.client, m.RunNamePattern, s.config)
+	} else if s.experimentID != "" {
+		log.Info().Str("uri", uri).Str("experiment_id", s.experimentID).Msg("monitoring active runs in experiment")
+		mlflow.MonitorExperiment(ctx, s.client, s.experimentID, s.config)
+	} else {
+		log.Info().Str("uri", uri).Msg("monitoring all active runs")
+		mlflow.MonitorAllActiveRuns(ctx, s.client, s.config)
+	}
+	return mlflow.PollResult{}
+}
+
+func (m *Monitor) target() string {
+	if m.RunID != "" {
+		return "run " + m.RunID
+	} else if m.RunNamePattern != "" {
+		return "runs named " + m.RunNamePattern
+	} else if m.ExperimentID != "" {
+		return "experiment " + m.ExperimentID
+	} else if m.ExperimentName != "" {
+		return "experiment " + m.ExperimentName
+	}
+	return "all active runs"
+}
+
+func (m *Monitor) notify(message string) {
+	if err := messaging.SendNotification(message, types.SeverityInfo, m.config); err != nil {
+		log.Error().Err(err).Msg("failed to send notification")
+	}
+}
+
+func formatThresholds(thresholds map[string]config.Threshold) string {
+	if len(thresholds) == 0 {
+		return "none"
+	}
+
+	metrics := make([]string, 0, len(thresholds))
+	for metric := range thresholds {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	parts := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		parts = append(parts, fmt.Sprintf("%s (%s)", metric, thresholds[metric]))
+	}
+	return strings.Join(parts, ", ")
+}
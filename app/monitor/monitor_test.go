@@ -0,0 +1,146 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/mlflow"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testConfig(uri string) config.Config {
+	return config.Config{
+		MLflowTrackingURI: uri,
+		PollInterval:      1,
+		MessageChannels:   "SLACK",
+		SlackWebhookURL:   uri + "/slack",
+	}
+}
+
+func TestCheckRunOnceUsesInjectedClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/2.0/mlflow/runs/get" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		run := types.Run{Info: types.RunInfo{RunID: r.URL.Query().Get("run_id"), Status: "FINISHED"}}
+		_ = json.NewEncoder(w).Encode(types.GetRunResponse{Run: run})
+	}))
+	defer server.Close()
+
+	cfg := testConfig(server.URL)
+	client, err := mlflow.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(cfg)
+	m.Client = client
+	defer m.Close()
+
+	result, err := m.CheckRunOnce(context.Background(), "run-1")
+	if err != nil {
+		t.Fatalf("CheckRunOnce() error = %v", err)
+	}
+	if result != (mlflow.PollResult{}) {
+		t.Errorf("CheckRunOnce() = %+v, want an empty result for a finished run", result)
+	}
+}
+
+func TestRunRejectsConflictingTargets(t *testing.T) {
+	m := New(testConfig("http://mlflow.invalid"))
+	m.RunID = "run-1"
+	m.RunNamePattern = "sweep-*"
+
+	_, err := m.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "run name pattern") {
+		t.Errorf("Run() error = %v, want the conflicting targets rejected", err)
+	}
+}
+
+func TestCheckOnceHonorsCancelledContext(t *testing.T) {
+	m := New(testConfig("http://mlflow.invalid"))
+	m.RunID = "run-1"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.CheckOnce(ctx); err == nil {
+		t.Error("CheckOnce() should fail once the context is cancelled")
+	}
+}
+
+func TestValidatePingsMLflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"reachable", http.StatusOK, false},
+		{"rejected credentials", http.StatusUnauthorized, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/2.0/mlflow/experiments/search" {
+					t.Errorf("unexpected request to %s", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			m := New(testConfig(server.URL))
+			defer m.Close()
+
+			if err := m.Validate(false); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListRunsCoversEveryTrackingServer(t *testing.T) {
+	newServer := func(runID string, token string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got := r.Header.Get("Authorization"); got != "Bearer "+token {
+				t.Errorf("Authorization = %q, want the token of this server", got)
+			}
+			run := types.Run{Info: types.RunInfo{RunID: runID, Status: "RUNNING"}}
+			if r.URL.Path == "/api/2.0/mlflow/runs/get" {
+				_ = json.NewEncoder(w).Encode(types.GetRunResponse{Run: run})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(types.GetRunsResponse{Runs: []types.Run{run}})
+		}))
+	}
+	onPrem := newServer("run-on-prem", "token-a")
+	defer onPrem.Close()
+	cloud := newServer("run-cloud", "token-b")
+	defer cloud.Close()
+
+	cfg := testConfig(onPrem.URL + "," + cloud.URL)
+	cfg.MLflowTrackingToken = "token-a,token-b"
+	m := New(cfg)
+	defer m.Close()
+
+	runs, err := m.ListRuns()
+	if err != nil {
+		t.Fatalf("ListRuns() error = %v", err)
+	}
+	if len(runs) != 2 || runs[0].Info.RunID != "run-on-prem" || runs[1].Info.RunID != "run-cloud" {
+		t.Errorf("ListRuns() = %+v, want one run from each server", runs)
+	}
+}
+
+func TestRunRejectsRunIDAcrossServers(t *testing.T) {
+	m := New(testConfig("http://a.invalid,http://b.invalid"))
+	m.RunID = "run-1"
+
+	if _, err := m.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "several tracking servers") {
+		t.Errorf("Run() error = %v, want a run ID across servers rejected", err)
+	}
+}
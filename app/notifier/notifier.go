@@ -0,0 +1,68 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"sort"
+	"sync"
+)
+
+// Notifier delivers a notification through one channel. Each channel
+// package registers its Notifier from init, so adding a channel does not
+// touch the dispatch in messaging.
+type Notifier interface {
+	// Name is the channel name used in MESSAGE_CHANNELS, e.g. SLACK.
+	Name() string
+	// Send delivers message. fields carry structured details that channels
+	// able to render them may use; others ignore them.
+	Send(ctx context.Context, message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error
+}
+
+// ErrSkipped is returned, possibly wrapped, by a Notifier that deliberately
+// did not deliver a message, e.g. because of a severity filter. It is not a
+// failure, but the message does not count as delivered either.
+var ErrSkipped = errors.New("notification skipped")
+
+var (
+	mu        sync.RWMutex
+	notifiers = map[string]Notifier{}
+)
+
+// Register makes n available under n.Name(). It panics when the name is
+// already taken, since two channels answering to one name is a programming
+// error.
+func Register(n Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	name := n.Name()
+	if _, taken := notifiers[name]; taken {
+		panic(fmt.Sprintf("notifier %q registered twice", name))
+	}
+	notifiers[name] = n
+}
+
+// Lookup returns the notifier registered under name.
+func Lookup(name string) (Notifier, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	n, ok := notifiers[name]
+	return n, ok
+}
+
+// Names returns the registered channel names in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(notifiers))
+	for name := range notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
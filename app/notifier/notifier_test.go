@@ -0,0 +1,43 @@
+package notifier
+
+import (
+	"context"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"testing"
+)
+
+type fakeNotifier struct {
+	name string
+}
+
+func (f fakeNotifier) Name() string {
+	return f.name
+}
+
+func (f fakeNotifier) Send(context.Context, string, types.Severity, config.Config, ...types.NotificationField) error {
+	return nil
+}
+
+func TestRegisterAndLookup(t *testing.T) {
+	Register(fakeNotifier{name: "FAKE"})
+
+	n, ok := Lookup("FAKE")
+	if !ok || n.Name() != "FAKE" {
+		t.Fatalf("Lookup(FAKE) = %v, %v, want the registered notifier", n, ok)
+	}
+	if _, ok := Lookup("MISSING"); ok {
+		t.Error("Lookup(MISSING) found a notifier that was never registered")
+	}
+}
+
+func TestRegisterPanicsOnDuplicateName(t *testing.T) {
+	Register(fakeNotifier{name: "TWICE"})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() did not panic on a duplicate name")
+		}
+	}()
+	Register(fakeNotifier{name: "TWICE"})
+}
@@ -0,0 +1,109 @@
+package opsgenie
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+)
+
+// maxMessageLength is the Alert API limit for message; the full text goes
+// into description.
+const maxMessageLength = 130
+
+// alertsURL is a variable so tests can point it at a stub server.
+var alertsURL = "https://api.opsgenie.com/v2/alerts"
+
+type Alert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Priority    string            `json:"priority,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Details     map[string]string `json:"details,omitempty"`
+}
+
+// SendOpsgenieNotification creates an alert. The run ID field is used as
+// alias so Opsgenie deduplicates repeated alerts for the same run.
+func SendOpsgenieNotification(message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	if config.OpsgenieAPIKey == "" {
+		return fmt.Errorf("opsgenie API key is not configured")
+	}
+
+	priority := "P3"
+	if severity == types.SeverityCritical {
+		priority = "P1"
+	}
+
+	summary := []rune(message)
+	if len(summary) > maxMessageLength {
+		summary = summary[:maxMessageLength]
+	}
+
+	alert := Alert{
+		Message:     string(summary),
+		Alias:       types.LookupField(fields, types.FieldRunID),
+		Description: message,
+		Priority:    priority,
+		Source:      "mlflow-autostop",
+	}
+	if len(fields) > 0 {
+		alert.Details = map[string]string{}
+		for _, field := range fields {
+			alert.Details[field.Key] = field.Value
+		}
+	}
+
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal opsgenie alert: %v", err)
+	}
+
+	client, err := httpclient.For(config)
+	if err != nil {
+		return fmt.Errorf("failed to send Opsgenie alert: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, alertsURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build Opsgenie request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+config.OpsgenieAPIKey)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Opsgenie alert: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("opsgenie API returned status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	log.Info().Msg("successfully sent Opsgenie alert")
+	return nil
+}
+
+func init() {
+	notifier.Register(Notifier{})
+}
+
+// Notifier sends the OPSGENIE channel's notifications through Opsgenie.
+type Notifier struct{}
+
+func (Notifier) Name() string {
+	return "OPSGENIE"
+}
+
+func (Notifier) Send(_ context.Context, message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	return SendOpsgenieNotification(message, severity, config, fields...)
+}
@@ -0,0 +1,68 @@
+package opsgenie
+
+import (
+	"encoding/json"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendOpsgenieNotification(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantErr      bool
+		wantPriority string
+		severity     types.Severity
+		fields       []types.NotificationField
+	}{
+		{"accepted", http.StatusAccepted, false, "P3", types.SeverityError, []types.NotificationField{
+			{Key: types.FieldRunID, Title: "Run ID", Value: "run-1"},
+		}},
+		{"critical is P1", http.StatusAccepted, false, "P1", types.SeverityCritical, []types.NotificationField{
+			{Key: types.FieldRunID, Title: "Run ID", Value: "run-1"},
+		}},
+		{"rejected", http.StatusUnauthorized, true, "P3", types.SeverityError, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received Alert
+			var auth string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = r.Header.Get("Authorization")
+				if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+					t.Errorf("failed to decode alert: %v", err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			original := alertsURL
+			alertsURL = server.URL
+			defer func() { alertsURL = original }()
+
+			message := "🚫 Stopping run run-1: " + strings.Repeat("x", 200)
+			err := SendOpsgenieNotification(message, tt.severity, config.Config{OpsgenieAPIKey: "key"}, tt.fields...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendOpsgenieNotification() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if auth != "GenieKey key" {
+				t.Errorf("Authorization = %q, want GenieKey header", auth)
+			}
+			if received.Alias != types.LookupField(tt.fields, types.FieldRunID) {
+				t.Errorf("alias = %q, want the run ID", received.Alias)
+			}
+			if received.Priority != tt.wantPriority {
+				t.Errorf("priority = %q, want %q", received.Priority, tt.wantPriority)
+			}
+			if len([]rune(received.Message)) > maxMessageLength || received.Description != message {
+				t.Errorf("message should be truncated and description hold the full text")
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package pagerduty
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+)
+
+// maxSummaryLength is the Events API v2 limit for payload.summary.
+const maxSummaryLength = 1024
+
+// eventsURL is a variable so tests can point it at a stub server.
+var eventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+type Event struct {
+	RoutingKey  string       `json:"routing_key"`
+	EventAction string       `json:"event_action"`
+	DedupKey    string       `json:"dedup_key,omitempty"`
+	Payload     EventPayload `json:"payload"`
+}
+
+type EventPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// SendPagerDutyNotification triggers an Events v2 alert. The run ID field is
+// used as dedup key so repeated detections of the same run update a single
+// incident. With PagerDutyOnlyCritical, non-critical messages are skipped
+// with notifier.ErrSkipped.
+func SendPagerDutyNotification(message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	if config.PagerDutyRoutingKey == "" {
+		return fmt.Errorf("pagerduty routing key is not configured")
+	}
+
+	if config.PagerDutyOnlyCritical && severity != types.SeverityCritical {
+		log.Debug().Msg("skipping PagerDuty for non-critical notification")
+		return fmt.Errorf("%w: PAGERDUTY_ONLY_CRITICAL is set and the message is %s", notifier.ErrSkipped, severity)
+	}
+
+	summary := []rune(message)
+	if len(summary) > maxSummaryLength {
+		summary = summary[:maxSummaryLength]
+	}
+
+	event := Event{
+		RoutingKey:  config.PagerDutyRoutingKey,
+		EventAction: "trigger",
+		DedupKey:    types.LookupField(fields, types.FieldRunID),
+		Payload: EventPayload{
+			Summary:  string(summary),
+			Source:   "mlflow-autostop",
+			Severity: string(severity),
+		},
+	}
+	if len(fields) > 0 {
+		event.Payload.CustomDetails = map[string]string{}
+		for _, field := range fields {
+			event.Payload.CustomDetails[field.Key] = field.Value
+		}
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pagerduty event: %v", err)
+	}
+
+	client, err := httpclient.For(config)
+	if err != nil {
+		return fmt.Errorf("failed to send PagerDuty event: %v", err)
+	}
+
+	resp, err := client.Post(eventsURL, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send PagerDuty event: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("pagerduty API returned status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	log.Info().Msg("successfully sent PagerDuty event")
+	return nil
+}
+
+func init() {
+	notifier.Register(Notifier{})
+}
+
+// Notifier sends the PAGERDUTY channel's notifications through PagerDuty.
+type Notifier struct{}
+
+func (Notifier) Name() string {
+	return "PAGERDUTY"
+}
+
+func (Notifier) Send(_ context.Context, message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	return SendPagerDutyNotification(message, severity, config, fields...)
+}
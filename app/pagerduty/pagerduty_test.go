@@ -0,0 +1,71 @@
+package pagerduty
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendPagerDutyNotification(t *testing.T) {
+	fields := []types.NotificationField{
+		{Key: types.FieldRunID, Title: "Run ID", Value: "run-1"},
+	}
+
+	tests := []struct {
+		name         string
+		onlyCritical bool
+		severity     types.Severity
+		wantSent     bool
+		wantSeverity string
+	}{
+		{"critical is sent", true, types.SeverityCritical, true, "critical"},
+		{"non-critical skipped", true, types.SeverityError, false, ""},
+		{"non-critical sent when not restricted", false, types.SeverityError, true, "error"},
+		{"warning keeps its severity", false, types.SeverityWarning, true, "warning"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received *Event
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = &Event{}
+				if err := json.NewDecoder(r.Body).Decode(received); err != nil {
+					t.Errorf("failed to decode event: %v", err)
+				}
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer server.Close()
+
+			original := eventsURL
+			eventsURL = server.URL
+			defer func() { eventsURL = original }()
+
+			cfg := config.Config{PagerDutyRoutingKey: "key", PagerDutyOnlyCritical: tt.onlyCritical}
+			err := SendPagerDutyNotification("stopping", tt.severity, cfg, fields...)
+			if tt.wantSent && err != nil {
+				t.Fatalf("SendPagerDutyNotification() error = %v", err)
+			}
+			if !tt.wantSent && !errors.Is(err, notifier.ErrSkipped) {
+				t.Fatalf("SendPagerDutyNotification() error = %v, want notifier.ErrSkipped", err)
+			}
+
+			if (received != nil) != tt.wantSent {
+				t.Fatalf("event sent = %v, want %v", received != nil, tt.wantSent)
+			}
+			if received == nil {
+				return
+			}
+			if received.DedupKey != types.LookupField(fields, types.FieldRunID) {
+				t.Errorf("dedup_key = %q, want the run ID", received.DedupKey)
+			}
+			if received.Payload.Severity != tt.wantSeverity {
+				t.Errorf("severity = %q, want %q", received.Payload.Severity, tt.wantSeverity)
+			}
+		})
+	}
+}
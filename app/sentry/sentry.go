@@ -0,0 +1,53 @@
+package sentry
+
+import (
+	"fmt"
+	sentrygo "github.com/getsentry/sentry-go"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/gidra39/mlflow-autostop/version"
+	"time"
+)
+
+// Init enables error reporting to config.SentryDSN. With an empty DSN it does
+// nothing and CaptureException stays a no-op. Events go through the shared
+// HTTP client, so HTTP_PROXY_URL and USER_AGENT apply to them too.
+func Init(config config.Config) error {
+	if config.SentryDSN == "" {
+		return nil
+	}
+
+	client, err := httpclient.For(config)
+	if err != nil {
+		return fmt.Errorf("failed to initialize sentry: %v", err)
+	}
+
+	err = sentrygo.Init(sentrygo.ClientOptions{
+		Dsn:        config.SentryDSN,
+		Release:    "mlflow-autostop@" + version.Version,
+		HTTPClient: client,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize sentry: %v", err)
+	}
+	return nil
+}
+
+// CaptureException reports err to Sentry in the background. It does nothing
+// unless Init was called with a DSN. Call Flush before exiting so pending
+// reports are not lost.
+func CaptureException(err error) {
+	if err == nil {
+		return
+	}
+	sentrygo.CaptureException(err)
+}
+
+// Flush waits up to timeout for pending reports to be sent and reports
+// whether they all were. Without a DSN there is nothing to send.
+func Flush(timeout time.Duration) bool {
+	if sentrygo.CurrentHub().Client() == nil {
+		return true
+	}
+	return sentrygo.Flush(timeout)
+}
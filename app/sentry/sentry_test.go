@@ -0,0 +1,70 @@
+package sentry
+
+import (
+	"errors"
+	"fmt"
+	sentrygo "github.com/getsentry/sentry-go"
+	"github.com/gidra39/mlflow-autostop/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func reset(t *testing.T) {
+	t.Cleanup(func() {
+		sentrygo.CurrentHub().BindClient(nil)
+	})
+}
+
+func TestCaptureExceptionSendsEvent(t *testing.T) {
+	reset(t)
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read event: %v", err)
+		}
+		received <- r.URL.Path + "\n" + string(body)
+	}))
+	defer server.Close()
+
+	dsn := strings.Replace(server.URL, "://", "://key@", 1) + "/1"
+	if err := Init(config.Config{SentryDSN: dsn}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	CaptureException(fmt.Errorf("failed to poll: %w", errors.New("connection refused")))
+	if !Flush(time.Second) {
+		t.Fatal("Flush() timed out")
+	}
+
+	event := <-received
+	if !strings.HasPrefix(event, "/api/1/envelope/") {
+		t.Errorf("event sent to %q, want the project envelope endpoint", strings.SplitN(event, "\n", 2)[0])
+	}
+	if !strings.Contains(event, "failed to poll: connection refused") {
+		t.Errorf("event = %s, want the captured error", event)
+	}
+}
+
+func TestInitRejectsInvalidDSN(t *testing.T) {
+	reset(t)
+	if err := Init(config.Config{SentryDSN: "https://o1.ingest.sentry.io/42"}); err == nil {
+		t.Error("Init() error = nil, want an error for a DSN without a public key")
+	}
+}
+
+func TestCaptureExceptionWithoutDSNIsNoop(t *testing.T) {
+	reset(t)
+	if err := Init(config.Config{}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	CaptureException(errors.New("boom"))
+	if !Flush(time.Second) {
+		t.Error("Flush() timed out with nothing to send")
+	}
+}
@@ -2,32 +2,72 @@ package slack
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gidra39/mlflow-autostop/config"
-	"log"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
 	"net/http"
 )
 
+// Attachment bar colors by severity.
+const (
+	stopColor    = "#d00000"
+	warningColor = "#daa038"
+)
+
 type SlackMessage struct {
+	Text        string            `json:"text"`
+	Blocks      []SlackBlock      `json:"blocks,omitempty"`
+	Attachments []SlackAttachment `json:"attachments,omitempty"`
+}
+
+type SlackAttachment struct {
+	Color  string       `json:"color,omitempty"`
+	Blocks []SlackBlock `json:"blocks,omitempty"`
+}
+
+type SlackBlock struct {
+	Type   string      `json:"type"`
+	Text   *SlackText  `json:"text,omitempty"`
+	Fields []SlackText `json:"fields,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"`
 	Text string `json:"text"`
 }
 
-func SendSlackNotification(message string, config config.Config) error {
-	if config.SlackWebhookURL == "" {
+// SendSlackNotification posts message to the Slack webhook for severity.
+// Stops go to SlackCriticalWebhookURL when it is set, everything else to
+// SlackWebhookURL.
+func SendSlackNotification(message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	webhookURL := webhookFor(severity, config)
+	if webhookURL == "" {
 		return fmt.Errorf("slack webhook URL is not configured")
 	}
 
 	slackMessage := SlackMessage{
 		Text: message,
 	}
+	if config.SlackUseBlocks {
+		slackMessage = blockMessage(message, severity, fields)
+	}
 
 	payload, err := json.Marshal(slackMessage)
 	if err != nil {
 		return fmt.Errorf("failed to marshal slack message: %v", err)
 	}
 
-	resp, err := http.Post(config.SlackWebhookURL, "application/json", bytes.NewBuffer(payload))
+	client, err := httpclient.For(config)
+	if err != nil {
+		return fmt.Errorf("failed to send Slack notification: %v", err)
+	}
+
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewBuffer(payload))
 	if err != nil {
 		return fmt.Errorf("failed to send Slack notification: %v", err)
 	}
@@ -37,6 +77,65 @@ func SendSlackNotification(message string, config config.Config) error {
 		return fmt.Errorf("Slack API returned status code %d", resp.StatusCode)
 	}
 
-	log.Println("Successfully sent Slack notification")
+	log.Info().Msg("successfully sent Slack notification")
 	return nil
 }
+
+func webhookFor(severity types.Severity, config config.Config) string {
+	switch severity {
+	case types.SeverityError, types.SeverityCritical:
+		if config.SlackCriticalWebhookURL != "" {
+			return config.SlackCriticalWebhookURL
+		}
+	}
+	return config.SlackWebhookURL
+}
+
+// blockMessage renders message as Block Kit inside an attachment. Messages
+// carrying stop details get a red bar, amber for warnings, and a section
+// listing the fields; the plain text stays as the fallback shown in push
+// notifications.
+func blockMessage(message string, severity types.Severity, fields []types.NotificationField) SlackMessage {
+	blocks := []SlackBlock{{
+		Type: "section",
+		Text: &SlackText{Type: "mrkdwn", Text: message},
+	}}
+
+	attachment := SlackAttachment{}
+	if len(fields) > 0 {
+		attachment.Color = stopColor
+		if severity == types.SeverityWarning {
+			attachment.Color = warningColor
+		}
+
+		section := SlackBlock{Type: "section"}
+		for _, field := range fields {
+			section.Fields = append(section.Fields, SlackText{
+				Type: "mrkdwn",
+				Text: fmt.Sprintf("*%s*\n%s", field.Title, field.Value),
+			})
+		}
+		blocks = append(blocks, section)
+	}
+	attachment.Blocks = blocks
+
+	return SlackMessage{
+		Text:        message,
+		Attachments: []SlackAttachment{attachment},
+	}
+}
+
+func init() {
+	notifier.Register(Notifier{})
+}
+
+// Notifier sends the SLACK channel's notifications through Slack.
+type Notifier struct{}
+
+func (Notifier) Name() string {
+	return "SLACK"
+}
+
+func (Notifier) Send(_ context.Context, message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	return SendSlackNotification(message, severity, config, fields...)
+}
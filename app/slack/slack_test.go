@@ -0,0 +1,100 @@
+package slack
+
+import (
+	"encoding/json"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendSlackNotificationWithBlocks(t *testing.T) {
+	var received SlackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.Config{SlackWebhookURL: server.URL, SlackUseBlocks: true}
+	fields := []types.NotificationField{
+		{Key: types.FieldRunID, Title: "Run ID", Value: "run-1"},
+		{Key: types.FieldMetric, Title: "Metric", Value: "loss"},
+	}
+
+	if err := SendSlackNotification("stopping run-1", types.SeverityError, cfg, fields...); err != nil {
+		t.Fatalf("SendSlackNotification() error = %v", err)
+	}
+
+	if received.Text != "stopping run-1" {
+		t.Errorf("fallback text = %q, want the message", received.Text)
+	}
+	if len(received.Attachments) != 1 {
+		t.Fatalf("got %d attachments, want 1", len(received.Attachments))
+	}
+
+	attachment := received.Attachments[0]
+	if attachment.Color != stopColor {
+		t.Errorf("attachment color = %q, want %q", attachment.Color, stopColor)
+	}
+	if len(attachment.Blocks) != 2 || len(attachment.Blocks[1].Fields) != len(fields) {
+		t.Errorf("expected a text section and a section with %d fields, got %+v", len(fields), attachment.Blocks)
+	}
+}
+
+func TestSendSlackNotificationPlainByDefault(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.Config{SlackWebhookURL: server.URL}
+	if err := SendSlackNotification("hello", types.SeverityInfo, cfg, types.NotificationField{Key: types.FieldRunID, Title: "Run ID", Value: "run-1"}); err != nil {
+		t.Fatalf("SendSlackNotification() error = %v", err)
+	}
+
+	if _, ok := received["attachments"]; ok || received["text"] != "hello" {
+		t.Errorf("expected a plain text payload, got %v", received)
+	}
+}
+
+func TestSendSlackNotificationRoutesOnSeverity(t *testing.T) {
+	var hits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := config.Config{
+		SlackWebhookURL:         server.URL + "/noise",
+		SlackCriticalWebhookURL: server.URL + "/critical",
+	}
+
+	tests := []struct {
+		severity types.Severity
+		want     string
+	}{
+		{types.SeverityInfo, "/noise"},
+		{types.SeverityWarning, "/noise"},
+		{types.SeverityError, "/critical"},
+		{types.SeverityCritical, "/critical"},
+	}
+
+	for _, tt := range tests {
+		hits = nil
+		if err := SendSlackNotification("message", tt.severity, cfg); err != nil {
+			t.Fatalf("SendSlackNotification(%q) error = %v", tt.severity, err)
+		}
+		if len(hits) != 1 || hits[0] != tt.want {
+			t.Errorf("severity %q posted to %v, want %s", tt.severity, hits, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// staleAfter is how long a run's state is kept after its last update.
+// Runs that finished or were stopped stop being updated and age out.
+const staleAfter = 7 * 24 * time.Hour
+
+// RunState is everything the monitor remembers about a single run between
+// polls and across restarts.
+type RunState struct {
+	ViolationCounts map[string]int       `json:"violation_counts,omitempty"`
+	BestValues      map[string]float64   `json:"best_values,omitempty"`
+	LastAlerts      map[string]time.Time `json:"last_alerts,omitempty"`
+	// MetricSeen tracks, per metric, the last logged timestamp observed and
+	// when the monitor first observed it.
+	MetricSeen map[string]MetricSeen `json:"metric_seen,omitempty"`
+	// StopRequestedAt is when a soft stop was requested, zero if none was.
+	StopRequestedAt time.Time `json:"stop_requested_at"`
+	// StoppedAt is when the run was stopped, zero if it was not.
+	StoppedAt time.Time `json:"stopped_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MetricSeen is the latest observation of a metric of a run. SeenAt is on
+// the monitor's clock, so staleness checks are unaffected by clock skew on
+// the machine that logged the metric.
+type MetricSeen struct {
+	Timestamp int64     `json:"timestamp"`
+	SeenAt    time.Time `json:"seen_at"`
+}
+
+// Store holds RunState keyed by run ID. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Get returns a copy of the state for runID.
+	Get(runID string) RunState
+	// Update applies fn to the state for runID and marks it as updated.
+	Update(runID string, fn func(*RunState))
+	// Delete forgets runID.
+	Delete(runID string)
+	// Save persists the current state.
+	Save() error
+}
+
+// MemoryStore keeps state in memory only; Save is a no-op.
+type MemoryStore struct {
+	mu   sync.Mutex
+	runs map[string]*RunState
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{runs: map[string]*RunState{}}
+}
+
+func (s *MemoryStore) Get(runID string) RunState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if run, ok := s.runs[runID]; ok {
+		return run.clone()
+	}
+	return RunState{}
+}
+
+func (s *MemoryStore) Update(runID string, fn func(*RunState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run, ok := s.runs[runID]
+	if !ok {
+		run = &RunState{}
+		s.runs[runID] = run
+	}
+	run.init()
+	fn(run)
+	run.UpdatedAt = time.Now()
+}
+
+func (s *MemoryStore) Delete(runID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.runs, runID)
+}
+
+func (s *MemoryStore) Save() error {
+	return nil
+}
+
+// FileStore is a MemoryStore persisted as JSON at a path. Writes go to a
+// temporary file first and are renamed into place so a crash never leaves a
+// truncated state file behind.
+type FileStore struct {
+	*MemoryStore
+	path string
+}
+
+// NewFileStore loads the state at path, starting empty if it does not exist.
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &store.runs); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %v", err)
+	}
+	return store, nil
+}
+
+func (s *FileStore) Save() error {
+	s.mu.Lock()
+	for runID, run := range s.runs {
+		if time.Since(run.UpdatedAt) > staleAfter {
+			delete(s.runs, runID)
+		}
+	}
+	data, err := json.MarshalIndent(s.runs, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+// New returns a FileStore when path is set and a MemoryStore otherwise.
+func New(path string) (Store, error) {
+	if path == "" {
+		return NewMemoryStore(), nil
+	}
+	return NewFileStore(path)
+}
+
+func (r *RunState) init() {
+	if r.ViolationCounts == nil {
+		r.ViolationCounts = map[string]int{}
+	}
+	if r.BestValues == nil {
+		r.BestValues = map[string]float64{}
+	}
+	if r.LastAlerts == nil {
+		r.LastAlerts = map[string]time.Time{}
+	}
+	if r.MetricSeen == nil {
+		r.MetricSeen = map[string]MetricSeen{}
+	}
+}
+
+func (r *RunState) clone() RunState {
+	c := RunState{StopRequestedAt: r.StopRequestedAt, StoppedAt: r.StoppedAt, UpdatedAt: r.UpdatedAt}
+	c.init()
+	for k, v := range r.ViolationCounts {
+		c.ViolationCounts[k] = v
+	}
+	for k, v := range r.BestValues {
+		c.BestValues[k] = v
+	}
+	for k, v := range r.LastAlerts {
+		c.LastAlerts[k] = v
+	}
+	for k, v := range r.MetricSeen {
+		c.MetricSeen[k] = v
+	}
+	return c
+}
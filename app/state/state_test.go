@@ -0,0 +1,75 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	alertedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store.Update("run-1", func(s *RunState) {
+		s.ViolationCounts["loss"] = 2
+		s.BestValues["accuracy"] = 0.9
+		s.LastAlerts["loss"] = alertedAt
+	})
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() reload error = %v", err)
+	}
+
+	got := reloaded.Get("run-1")
+	if got.ViolationCounts["loss"] != 2 || got.BestValues["accuracy"] != 0.9 || !got.LastAlerts["loss"].Equal(alertedAt) {
+		t.Errorf("reloaded state = %+v, want the saved values", got)
+	}
+}
+
+func TestFileStoreDropsStaleRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Update("old", func(s *RunState) { s.ViolationCounts["loss"] = 1 })
+	store.runs["old"].UpdatedAt = time.Now().Add(-2 * staleAfter)
+	store.Update("fresh", func(s *RunState) { s.ViolationCounts["loss"] = 1 })
+
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.runs["old"]; ok {
+		t.Error("stale run should have been dropped on save")
+	}
+	if _, ok := reloaded.runs["fresh"]; !ok {
+		t.Error("fresh run should have been kept")
+	}
+}
+
+func TestGetReturnsCopy(t *testing.T) {
+	store := NewMemoryStore()
+	store.Update("run-1", func(s *RunState) { s.ViolationCounts["loss"] = 1 })
+
+	got := store.Get("run-1")
+	got.ViolationCounts["loss"] = 99
+
+	if store.Get("run-1").ViolationCounts["loss"] != 1 {
+		t.Error("mutating the result of Get must not change the store")
+	}
+}
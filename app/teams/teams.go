@@ -0,0 +1,115 @@
+package teams
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type MessageCard struct {
+	Type       string           `json:"@type"`
+	Context    string           `json:"@context"`
+	Summary    string           `json:"summary"`
+	ThemeColor string           `json:"themeColor,omitempty"`
+	Title      string           `json:"title"`
+	Text       string           `json:"text"`
+	Sections   []MessageSection `json:"sections,omitempty"`
+}
+
+type MessageSection struct {
+	Facts []MessageFact `json:"facts"`
+}
+
+type MessageFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Card theme colors by severity.
+const (
+	stopColor    = "d00000"
+	warningColor = "daa038"
+)
+
+// SendTeamsNotification posts message as a MessageCard, listing fields as
+// facts. Warnings get an amber accent and everything else with fields red.
+func SendTeamsNotification(message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	if config.TeamsWebhookURL == "" {
+		return fmt.Errorf("teams webhook URL is not configured")
+	}
+
+	card := MessageCard{
+		Type:    "MessageCard",
+		Context: "http://schema.org/extensions",
+		Summary: message,
+		Title:   "MLflow autostop",
+		Text:    message,
+	}
+	if len(fields) > 0 {
+		card.ThemeColor = stopColor
+		if severity == types.SeverityWarning {
+			card.ThemeColor = warningColor
+		}
+		section := MessageSection{}
+		for _, field := range fields {
+			section.Facts = append(section.Facts, MessageFact{Name: field.Title, Value: field.Value})
+		}
+		card.Sections = []MessageSection{section}
+	}
+
+	payload, err := json.Marshal(card)
+	if err != nil {
+		return fmt.Errorf("failed to marshal teams message: %v", err)
+	}
+
+	client, err := httpclient.For(config)
+	if err != nil {
+		return fmt.Errorf("failed to send Teams notification: %v", err)
+	}
+
+	resp, err := client.Post(config.TeamsWebhookURL, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send Teams notification: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	// Incoming Webhook connectors answer 200 with a body of "1" on success
+	// and 200 with an error description otherwise; Workflows answer 202.
+	switch {
+	case resp.StatusCode == http.StatusAccepted:
+	case resp.StatusCode == http.StatusOK && strings.TrimSpace(string(body)) == "1":
+	case resp.StatusCode == http.StatusOK:
+		return fmt.Errorf("teams webhook rejected the message: %s", string(body))
+	default:
+		return fmt.Errorf("teams API returned status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	log.Info().Msg("successfully sent Teams notification")
+	return nil
+}
+
+func init() {
+	notifier.Register(Notifier{})
+}
+
+// Notifier sends the TEAMS channel's notifications through Microsoft Teams.
+type Notifier struct{}
+
+func (Notifier) Name() string {
+	return "TEAMS"
+}
+
+func (Notifier) Send(_ context.Context, message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	return SendTeamsNotification(message, severity, config, fields...)
+}
@@ -0,0 +1,38 @@
+package teams
+
+import (
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendTeamsNotificationResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"connector success", http.StatusOK, "1", false},
+		{"workflow accepted", http.StatusAccepted, "", false},
+		{"connector error body", http.StatusOK, "Webhook message delivery failed", true},
+		{"bad request", http.StatusBadRequest, "Invalid webhook URL", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			err := SendTeamsNotification("hello", types.SeverityInfo, config.Config{TeamsWebhookURL: server.URL})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SendTeamsNotification() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
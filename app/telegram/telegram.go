@@ -1,31 +1,167 @@
 package telegram
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gidra39/mlflow-autostop/config"
-	"log"
+	"github.com/gidra39/mlflow-autostop/httpclient"
+	"github.com/gidra39/mlflow-autostop/notifier"
+	"github.com/gidra39/mlflow-autostop/types"
+	"github.com/rs/zerolog/log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func SendTelegramNotification(message string, config config.Config) error {
-	endpoint := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", config.TelegramBotToken)
+// maxMessageLength is the Telegram sendMessage limit in characters.
+const maxMessageLength = 4096
+
+// maxRetryAfter caps how long a rate-limited send waits before its retry,
+// so a large retry_after cannot stall a poll indefinitely.
+const maxRetryAfter = 30 * time.Second
+
+// apiBaseURL is a variable so tests can point it at a stub server.
+var apiBaseURL = "https://api.telegram.org"
+
+// sleep is a variable so tests can skip the rate limit wait.
+var sleep = time.Sleep
+
+// apiError is the body Telegram returns for a failed call. On 429 responses
+// Parameters.RetryAfter holds the seconds to wait before sending again.
+type apiError struct {
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// SendTelegramNotification sends message to the configured chat, split into
+// as many messages as the length limit requires. Informational messages are
+// delivered silently.
+func SendTelegramNotification(message string, severity types.Severity, config config.Config) error {
+	if config.TelegramBotToken == "" || config.TelegramChatID == "" {
+		return fmt.Errorf("telegram bot token or chat ID is not configured")
+	}
+
+	chunks := splitMessage(message, maxMessageLength)
+	silent := severity == types.SeverityInfo
+
+	var errs []error
+	for i, chunk := range chunks {
+		if err := sendMessage(chunk, silent, config); err != nil {
+			errs = append(errs, fmt.Errorf("chunk %d/%d: %v", i+1, len(chunks), err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	log.Info().Msg("successfully sent Telegram notification")
+	return nil
+}
+
+func sendMessage(message string, silent bool, config config.Config) error {
+	endpoint := fmt.Sprintf("%s/bot%s/sendMessage", apiBaseURL, config.TelegramBotToken)
 
 	params := url.Values{}
 	params.Add("chat_id", config.TelegramChatID)
 	params.Add("text", message)
 	params.Add("parse_mode", "HTML")
+	if silent {
+		params.Add("disable_notification", "true")
+	}
+	if config.TelegramMessageThreadID != 0 {
+		params.Add("message_thread_id", strconv.Itoa(config.TelegramMessageThreadID))
+	}
 
-	resp, err := http.PostForm(endpoint, params)
+	client, err := httpclient.For(config)
 	if err != nil {
 		return fmt.Errorf("failed to send Telegram notification: %v", err)
 	}
+
+	retryAfter, err := postMessage(client, endpoint, params)
+	if retryAfter > 0 {
+		wait := min(retryAfter, maxRetryAfter)
+		log.Warn().Dur("retry_after", retryAfter).Dur("wait", wait).Msg("rate limited by Telegram, retrying once")
+		sleep(wait)
+		_, err = postMessage(client, endpoint, params)
+	}
+	return err
+}
+
+// postMessage makes one sendMessage call. When Telegram rate limits it, the
+// returned duration is the retry_after it asked for.
+func postMessage(client *http.Client, endpoint string, params url.Values) (time.Duration, error) {
+	resp, err := client.PostForm(endpoint, params)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send Telegram notification: %v", err)
+	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("telegram API returned status code %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusOK {
+		return 0, nil
 	}
 
-	log.Println("Successfully sent Telegram notification")
-	return nil
+	var body apiError
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	err = fmt.Errorf("telegram API returned status code %d: %s", resp.StatusCode, body.Description)
+	if resp.StatusCode == http.StatusTooManyRequests && body.Parameters.RetryAfter > 0 {
+		return time.Duration(body.Parameters.RetryAfter) * time.Second, err
+	}
+	return 0, err
+}
+
+// splitMessage breaks message into chunks of at most limit characters,
+// splitting on line boundaries where possible and inside a line only when
+// the line alone exceeds the limit.
+func splitMessage(message string, limit int) []string {
+	if len([]rune(message)) <= limit {
+		return []string{message}
+	}
+
+	var chunks []string
+	var current []rune
+
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, string(current))
+			current = nil
+		}
+	}
+
+	for _, line := range strings.SplitAfter(message, "\n") {
+		runes := []rune(line)
+
+		if len(current)+len(runes) > limit {
+			flush()
+		}
+
+		for len(runes) > limit {
+			chunks = append(chunks, string(runes[:limit]))
+			runes = runes[limit:]
+		}
+		current = append(current, runes...)
+	}
+	flush()
+
+	return chunks
+}
+
+func init() {
+	notifier.Register(Notifier{})
+}
+
+// Notifier sends the TELEGRAM channel's notifications through Telegram.
+type Notifier struct{}
+
+func (Notifier) Name() string {
+	return "TELEGRAM"
+}
+
+func (Notifier) Send(_ context.Context, message string, severity types.Severity, config config.Config, fields ...types.NotificationField) error {
+	return SendTelegramNotification(message, severity, config)
 }
@@ -0,0 +1,125 @@
+package telegram
+
+import (
+	"github.com/gidra39/mlflow-autostop/config"
+	"github.com/gidra39/mlflow-autostop/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		limit   int
+		want    []string
+	}{
+		{"short message", "hello", 10, []string{"hello"}},
+		{"splits on lines", "aaaa\nbbbb\ncccc", 10, []string{"aaaa\nbbbb\n", "cccc"}},
+		{"long line is hard split", "aaaaaaaaaaaa", 5, []string{"aaaaa", "aaaaa", "aa"}},
+		{"counts characters not bytes", "ééééé\néé", 6, []string{"ééééé\n", "éé"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.message, tt.limit)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("splitMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendTelegramNotificationSendsEveryChunk(t *testing.T) {
+	var texts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		texts = append(texts, r.FormValue("text"))
+		if r.FormValue("message_thread_id") != "42" {
+			t.Errorf("message_thread_id = %q, want 42", r.FormValue("message_thread_id"))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	original := apiBaseURL
+	apiBaseURL = server.URL
+	defer func() { apiBaseURL = original }()
+
+	line := strings.Repeat("x", 3000) + "\n"
+	cfg := config.Config{TelegramBotToken: "token", TelegramChatID: "1", TelegramMessageThreadID: 42}
+
+	if err := SendTelegramNotification(line+line, types.SeverityError, cfg); err != nil {
+		t.Fatalf("SendTelegramNotification() error = %v", err)
+	}
+	if len(texts) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(texts))
+	}
+}
+
+func TestSendTelegramNotificationSilencesInfo(t *testing.T) {
+	silent := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		silent[r.FormValue("text")] = r.FormValue("disable_notification")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	original := apiBaseURL
+	apiBaseURL = server.URL
+	defer func() { apiBaseURL = original }()
+
+	cfg := config.Config{TelegramBotToken: "token", TelegramChatID: "1"}
+	for _, severity := range []types.Severity{types.SeverityInfo, types.SeverityError} {
+		if err := SendTelegramNotification(string(severity), severity, cfg); err != nil {
+			t.Fatalf("SendTelegramNotification() error = %v", err)
+		}
+	}
+
+	if silent["info"] != "true" || silent["error"] != "" {
+		t.Errorf("disable_notification = %v, want only info silenced", silent)
+	}
+}
+
+func TestSendTelegramNotificationRetriesAfterRateLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		wantWait   time.Duration
+	}{
+		{"waits retry_after", "3", 3 * time.Second},
+		{"caps long waits", "600", maxRetryAfter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls == 1 {
+					w.WriteHeader(http.StatusTooManyRequests)
+					w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests","parameters":{"retry_after":` + tt.retryAfter + `}}`))
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			originalURL, originalSleep := apiBaseURL, sleep
+			var waited time.Duration
+			apiBaseURL = server.URL
+			sleep = func(d time.Duration) { waited = d }
+			defer func() { apiBaseURL, sleep = originalURL, originalSleep }()
+
+			cfg := config.Config{TelegramBotToken: "token", TelegramChatID: "1"}
+			if err := SendTelegramNotification("stopping", types.SeverityError, cfg); err != nil {
+				t.Fatalf("SendTelegramNotification() error = %v", err)
+			}
+			if calls != 2 || waited != tt.wantWait {
+				t.Errorf("made %d calls after waiting %v, want 2 calls after %v", calls, waited, tt.wantWait)
+			}
+		})
+	}
+}
@@ -1,5 +1,11 @@
 package types
 
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
 // Configuration structure
 type AppConfig struct {
 	MLflowTrackingURI string             `json:"mlflow_tracking_uri"`
@@ -9,10 +15,57 @@ type AppConfig struct {
 	MetricThresholds  map[string]float64 `json:"metric_thresholds"`
 }
 
+// Keys of the well-known notification fields.
+const (
+	FieldRunID     = "run_id"
+	FieldMetric    = "metric"
+	FieldValue     = "value"
+	FieldThreshold = "threshold"
+	FieldSeverity  = "severity"
+)
+
+// Severity ranks a notification so channels can route or format it. The
+// levels follow the PagerDuty Events v2 severities.
+type Severity string
+
+const (
+	// SeverityInfo marks lifecycle and summary messages.
+	SeverityInfo Severity = "info"
+	// SeverityWarning marks a metric past its warning threshold.
+	SeverityWarning Severity = "warning"
+	// SeverityError marks a run being stopped.
+	SeverityError Severity = "error"
+	// SeverityCritical marks stops that warrant paging someone.
+	SeverityCritical Severity = "critical"
+)
+
+// NotificationField is a titled detail attached to a notification, rendered
+// as a structured field by channels that support it. Key identifies the
+// field for channels that consume it programmatically.
+type NotificationField struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// LookupField returns the value of the field with key, or "" if absent.
+func LookupField(fields []NotificationField, key string) string {
+	for _, field := range fields {
+		if field.Key == key {
+			return field.Value
+		}
+	}
+	return ""
+}
+
 type RunInfo struct {
-	RunID        string `json:"run_id"`
-	Status       string `json:"status"`
-	ExperimentID string `json:"experiment_id"`
+	RunID          string `json:"run_id"`
+	RunName        string `json:"run_name"`
+	Status         string `json:"status"`
+	ExperimentID   string `json:"experiment_id"`
+	UserID         string `json:"user_id"`
+	StartTime      int64  `json:"start_time"`
+	LifecycleStage string `json:"lifecycle_stage,omitempty"`
 }
 
 type Metric struct {
@@ -22,20 +75,115 @@ type Metric struct {
 	Step      int     `json:"step"`
 }
 
+// UnmarshalJSON accepts the "NaN", "Infinity" and "-Infinity" strings MLflow
+// uses to encode non-finite metric values alongside plain JSON numbers.
+func (m *Metric) UnmarshalJSON(data []byte) error {
+	type metric Metric
+	aux := struct {
+		*metric
+		Value json.RawMessage `json:"value"`
+	}{metric: (*metric)(m)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if len(aux.Value) == 0 {
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(aux.Value, &s); err == nil {
+		switch s {
+		case "NaN":
+			m.Value = math.NaN()
+		case "Infinity":
+			m.Value = math.Inf(1)
+		case "-Infinity":
+			m.Value = math.Inf(-1)
+		default:
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return err
+			}
+			m.Value = v
+		}
+		return nil
+	}
+
+	return json.Unmarshal(aux.Value, &m.Value)
+}
+
+type Param struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type RunTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type RunData struct {
+	Metrics []Metric `json:"metrics"`
+	Params  []Param  `json:"params,omitempty"`
+	Tags    []RunTag `json:"tags,omitempty"`
+}
+
+// LookupParam returns the value of the param with key.
+func LookupParam(params []Param, key string) (string, bool) {
+	for _, param := range params {
+		if param.Key == key {
+			return param.Value, true
+		}
+	}
+	return "", false
+}
+
+// LookupTag returns the value of the tag with key.
+func LookupTag(tags []RunTag, key string) (string, bool) {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+type Run struct {
+	Info RunInfo `json:"info"`
+	Data RunData `json:"data"`
+}
+
 type GetRunsResponse struct {
-	Runs []struct {
-		Info RunInfo `json:"info"`
-		Data struct {
-			Metrics []Metric `json:"metrics"`
-		} `json:"data"`
-	} `json:"runs"`
+	Runs          []Run  `json:"runs"`
+	NextPageToken string `json:"next_page_token,omitempty"`
 }
 
 type GetRunResponse struct {
-	Run struct {
-		Info RunInfo `json:"info"`
-		Data struct {
-			Metrics []Metric `json:"metrics"`
-		} `json:"data"`
-	} `json:"run"`
+	Run Run `json:"run"`
+}
+
+type Experiment struct {
+	ExperimentID     string `json:"experiment_id"`
+	Name             string `json:"name"`
+	ArtifactLocation string `json:"artifact_location"`
+	LifecycleStage   string `json:"lifecycle_stage"`
+}
+
+type GetExperimentResponse struct {
+	Experiment Experiment `json:"experiment"`
+}
+
+type GetMetricHistoryResponse struct {
+	Metrics       []Metric `json:"metrics"`
+	NextPageToken string   `json:"next_page_token,omitempty"`
+}
+
+type SearchRunsRequest struct {
+	ExperimentIDs []string `json:"experiment_ids,omitempty"`
+	Filter        string   `json:"filter,omitempty"`
+	RunViewType   string   `json:"run_view_type,omitempty"`
+	MaxResults    int      `json:"max_results,omitempty"`
+	PageToken     string   `json:"page_token,omitempty"`
 }
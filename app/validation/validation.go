@@ -1,7 +1,27 @@
 package validation
 
 import (
+	"github.com/gidra39/mlflow-autostop/expression"
 	"github.com/go-playground/validator/v10"
+	"regexp"
 )
 
 var Validate = validator.New()
+
+// telegramChatIDPattern matches a numeric chat ID (negative for groups and
+// channels) or a public channel @username.
+var telegramChatIDPattern = regexp.MustCompile(`^(-?\d+|@[A-Za-z][A-Za-z0-9_]{4,})$`)
+
+func init() {
+	if err := Validate.RegisterValidation("telegram_chat_id", func(fl validator.FieldLevel) bool {
+		return telegramChatIDPattern.MatchString(fl.Field().String())
+	}); err != nil {
+		panic(err)
+	}
+	if err := Validate.RegisterValidation("metric_expression", func(fl validator.FieldLevel) bool {
+		_, err := expression.Parse(fl.Field().String())
+		return err == nil
+	}); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,27 @@
+package validation
+
+import "testing"
+
+func TestTelegramChatIDValidation(t *testing.T) {
+	tests := []struct {
+		chatID string
+		valid  bool
+	}{
+		{"123456789", true},
+		{"-1001234567890", true},
+		{"@ml_alerts", true},
+		{"", true},
+		{"@abc", false},
+		{"ml_alerts", false},
+		{"12ab", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.chatID, func(t *testing.T) {
+			err := Validate.Var(tt.chatID, "omitempty,telegram_chat_id")
+			if (err == nil) != tt.valid {
+				t.Errorf("validate(%q) error = %v, want valid=%v", tt.chatID, err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package version
+
+// Version is the build version, injected at link time with
+//
+//	go build -ldflags "-X github.com/gidra39/mlflow-autostop/version.Version=v1.2.3"
+var Version = "dev"
+
+// UserAgent is the default User-Agent of outbound requests,
+// mlflow-autostop/<Version>.
+func UserAgent() string {
+	return "mlflow-autostop/" + Version
+}